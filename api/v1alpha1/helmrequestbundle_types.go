@@ -0,0 +1,148 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HelmRequestBundlePhase is the aggregated phase of the members of a HelmRequestBundle
+type HelmRequestBundlePhase string
+
+const (
+	// HelmRequestBundlePending means some of the members are not synced yet
+	HelmRequestBundlePending HelmRequestBundlePhase = "Pending"
+	// HelmRequestBundleSynced means all the members are synced
+	HelmRequestBundleSynced HelmRequestBundlePhase = "Synced"
+	// HelmRequestBundleFailed means the spec is invalid, or some of the members failed to sync
+	HelmRequestBundleFailed HelmRequestBundlePhase = "Failed"
+	// HelmRequestBundleDeleting means the members are being deleted in the reverse order
+	HelmRequestBundleDeleting HelmRequestBundlePhase = "Deleting"
+)
+
+// HelmRequestBundleSpec defines a group of charts installed as one unit
+type HelmRequestBundleSpec struct {
+	// ClusterName is the default cluster of the members
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// InstallToAllClusters installs all the members to all the clusters
+	InstallToAllClusters bool `json:"installToAllClusters,omitempty"`
+
+	// Namespace is the default namespace of the releases of the members
+	Namespace string `json:"namespace,omitempty"`
+
+	// ValuesFrom are shared by all the members, before the valuesFrom of each member
+	ValuesFrom []appv1.ValuesFromSource `json:"valuesFrom,omitempty"`
+
+	// Values are shared by all the members, the values of a member override them
+	appv1.HelmValues `json:",inline"`
+
+	// Members are the charts to install, each one generates a HelmRequest named <bundle>-<member>
+	Members []HelmRequestBundleMember `json:"members"`
+}
+
+// HelmRequestBundleMember is a chart in the bundle
+type HelmRequestBundleMember struct {
+	// Name is the name of the member, unique in the bundle
+	Name string `json:"name"`
+
+	// Chart is the chart name, the same as the HelmRequest's
+	Chart string `json:"chart"`
+
+	// Version is the chart version, the same as the HelmRequest's
+	Version string `json:"version,omitempty"`
+
+	// ReleaseName defaults to the name of the generated HelmRequest
+	ReleaseName string `json:"releaseName,omitempty"`
+
+	// ClusterName overrides the cluster of the bundle
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Namespace overrides the namespace of the bundle
+	Namespace string `json:"namespace,omitempty"`
+
+	// DependsOn are the members to be synced before this one, each one is <member>[:Synced|Ready|Healthy]
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// Annotations are added to the generated HelmRequest, eg: captain-deletion-policy
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// ValuesFrom are appended to the valuesFrom of the bundle
+	ValuesFrom []appv1.ValuesFromSource `json:"valuesFrom,omitempty"`
+
+	// Values are merged into the values of the bundle
+	appv1.HelmValues `json:",inline"`
+}
+
+// HelmRequestBundleMemberStatus is the state of the HelmRequest of a member
+type HelmRequestBundleMemberStatus struct {
+	// Name is the name of the member
+	Name string `json:"name"`
+
+	// HelmRequest is the name of the generated HelmRequest
+	HelmRequest string `json:"helmRequest"`
+
+	// Phase is the phase of the HelmRequest, empty if it's not created yet
+	Phase appv1.HelmRequestPhase `json:"phase,omitempty"`
+
+	// Reason is why the HelmRequest failed or is waiting
+	Reason string `json:"reason,omitempty"`
+}
+
+// HelmRequestBundleStatus is the aggregated status of the members
+type HelmRequestBundleStatus struct {
+	// Phase is Synced if all the members are synced, Failed if any of them failed
+	Phase HelmRequestBundlePhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the members are generated from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Reason is why the bundle is failed, eg: the members form a cycle
+	Reason string `json:"reason,omitempty"`
+
+	// Members are the states of the members, in the order of the spec
+	Members []HelmRequestBundleMemberStatus `json:"members,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=hrb
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HelmRequestBundle installs a group of charts as one ordered unit, by generating and owning a HelmRequest for
+// each member
+type HelmRequestBundle struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HelmRequestBundleSpec   `json:"spec,omitempty"`
+	Status HelmRequestBundleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HelmRequestBundleList contains a list of HelmRequestBundle
+type HelmRequestBundleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HelmRequestBundle `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HelmRequestBundle{}, &HelmRequestBundleList{})
+}
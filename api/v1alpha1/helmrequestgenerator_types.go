@@ -0,0 +1,130 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HelmRequestGeneratorPhase is the phase of a HelmRequestGenerator
+type HelmRequestGeneratorPhase string
+
+const (
+	// HelmRequestGeneratorReady means the HelmRequests are generated from the sources
+	HelmRequestGeneratorReady HelmRequestGeneratorPhase = "Ready"
+	// HelmRequestGeneratorFailed means the sources or the template are invalid
+	HelmRequestGeneratorFailed HelmRequestGeneratorPhase = "Failed"
+)
+
+// HelmRequestGeneratorSpec defines the sources of the parameters, and the template to render with them
+type HelmRequestGeneratorSpec struct {
+	// Generators are the sources of the parameters, a HelmRequest is generated for each set of the parameters
+	// from all of them
+	Generators []Generator `json:"generators"`
+
+	// Template is rendered with each set of the parameters, {{<name>}} in all the strings are replaced
+	Template HelmRequestTemplate `json:"template"`
+}
+
+// Generator is a source of the parameters, only one of it's fields may be set
+type Generator struct {
+	// List generates a set of parameters for each element
+	List *ListGenerator `json:"list,omitempty"`
+
+	// Clusters generates a set of parameters for each registered cluster
+	Clusters *ClusterGenerator `json:"clusters,omitempty"`
+
+	// Git generates a set of parameters for each chart directory discovered in a Git ChartRepo
+	Git *GitGenerator `json:"git,omitempty"`
+}
+
+// ListGenerator is a static list of parameters
+type ListGenerator struct {
+	Elements []map[string]string `json:"elements"`
+}
+
+// ClusterGenerator generates the parameters name, endpoint and label.<key> of the clusters
+type ClusterGenerator struct {
+	// Selector selects the clusters by their labels, all the clusters if it's empty
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// GitGenerator generates the parameters chart, chart.name, chart.version and repo of the charts discovered
+// in a Git/SVN ChartRepo
+type GitGenerator struct {
+	// ChartRepo is the name of the ChartRepo
+	ChartRepo string `json:"chartRepo"`
+
+	// Charts are the glob patterns of the chart names to select, all the charts if it's empty
+	Charts []string `json:"charts,omitempty"`
+}
+
+// HelmRequestTemplateMeta is the metadata of the generated HelmRequests, the namespace is the generator's
+type HelmRequestTemplateMeta struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// HelmRequestTemplate is the template of the generated HelmRequests
+type HelmRequestTemplate struct {
+	Metadata HelmRequestTemplateMeta `json:"metadata"`
+	Spec     appv1.HelmRequestSpec   `json:"spec"`
+}
+
+// HelmRequestGeneratorStatus is the result of the last generation
+type HelmRequestGeneratorStatus struct {
+	Phase HelmRequestGeneratorPhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the HelmRequests are generated from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Reason is why the generation failed
+	Reason string `json:"reason,omitempty"`
+
+	// HelmRequests are the names of the generated HelmRequests
+	HelmRequests []string `json:"helmRequests,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=hrg
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HelmRequestGenerator generates and owns HelmRequests from a template and the parameters of the generators,
+// the HelmRequests disappeared from the generators are pruned
+type HelmRequestGenerator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HelmRequestGeneratorSpec   `json:"spec,omitempty"`
+	Status HelmRequestGeneratorStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HelmRequestGeneratorList contains a list of HelmRequestGenerator
+type HelmRequestGeneratorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HelmRequestGenerator `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HelmRequestGenerator{}, &HelmRequestGeneratorList{})
+}
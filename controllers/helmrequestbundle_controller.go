@@ -0,0 +1,211 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/bundle"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// bundleDeletionRecheckInterval is how often to check the members being deleted, the HelmRequests are
+// watched, this is only a fallback
+const bundleDeletionRecheckInterval = 10 * time.Second
+
+// HelmRequestBundleReconciler reconciles a HelmRequestBundle object, it generates and owns a HelmRequest for
+// each member, and aggregates their phases
+type HelmRequestBundleReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequestbundles,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequestbundles/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequests,verbs=get;list;watch;create;update;patch;delete
+
+func (r *HelmRequestBundleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("helmrequestbundle", req.NamespacedName)
+
+	var b bundlev1alpha1.HelmRequestBundle
+	if err := r.Get(ctx, req.NamespacedName, &b); err != nil {
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	children, err := r.listMembers(ctx, &b)
+	if err != nil {
+		log.Error(err, "list members error")
+		return ctrl.Result{}, err
+	}
+
+	if !b.DeletionTimestamp.IsZero() {
+		return r.deleteMembers(ctx, &b, children)
+	}
+
+	if !controllerutil.ContainsFinalizer(&b, util.FinalizerName) {
+		controllerutil.AddFinalizer(&b, util.FinalizerName)
+		if err := r.Update(ctx, &b); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	desired, err := bundle.Render(&b)
+	if err != nil {
+		log.Error(err, "invalid bundle")
+		if b.Status.Phase == bundlev1alpha1.HelmRequestBundleFailed && b.Status.Reason == err.Error() {
+			return ctrl.Result{}, nil
+		}
+		b.Status.Phase = bundlev1alpha1.HelmRequestBundleFailed
+		b.Status.Reason = err.Error()
+		return ctrl.Result{}, r.Status().Update(ctx, &b)
+	}
+
+	current := make(map[string]*appv1.HelmRequest)
+	for _, hr := range children {
+		current[hr.Name] = hr
+	}
+	for _, hr := range desired {
+		if err := r.applyMember(ctx, &b, hr, current[hr.Name]); err != nil {
+			log.Error(err, "apply member error", "helmrequest", hr.Name)
+			return ctrl.Result{}, err
+		}
+		delete(current, hr.Name)
+	}
+
+	// members removed from the spec, the HelmRequest controller keeps their releases until nothing depends on them
+	for _, hr := range current {
+		log.Info("delete removed member", "helmrequest", hr.Name)
+		if err := r.Delete(ctx, hr); err != nil && !isNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	original := b.Status.DeepCopy()
+	b.Status.ObservedGeneration = b.Generation
+	b.Status.Reason = ""
+	return ctrl.Result{}, r.updateStatus(ctx, &b, original, children)
+}
+
+// listMembers lists the HelmRequests generated for the bundle
+func (r *HelmRequestBundleReconciler) listMembers(ctx context.Context, b *bundlev1alpha1.HelmRequestBundle) ([]*appv1.HelmRequest, error) {
+	var list appv1.HelmRequestList
+	if err := r.List(ctx, &list, client.InNamespace(b.Namespace), client.MatchingLabels{bundle.BundleLabel: b.Name}); err != nil {
+		return nil, err
+	}
+	var result []*appv1.HelmRequest
+	for i := range list.Items {
+		// skip the HelmRequests with the same label but not generated by the bundle
+		if metav1.IsControlledBy(&list.Items[i], b) {
+			result = append(result, &list.Items[i])
+		}
+	}
+	return result, nil
+}
+
+// applyMember creates the HelmRequest of a member, or updates it if the generated fields are changed
+func (r *HelmRequestBundleReconciler) applyMember(ctx context.Context, b *bundlev1alpha1.HelmRequestBundle, desired, current *appv1.HelmRequest) error {
+	if err := controllerutil.SetControllerReference(b, desired, r.Scheme); err != nil {
+		return err
+	}
+	if current == nil {
+		r.Log.Info("create member", "helmrequest", desired.Name)
+		return r.Create(ctx, desired)
+	}
+
+	updated := mergeGenerated(current, desired)
+	if updated == nil {
+		return nil
+	}
+	r.Log.Info("update member", "helmrequest", desired.Name)
+	return r.Update(ctx, updated)
+}
+
+// mergeGenerated applies the labels, annotations and spec of the generated HelmRequest to the current one, the
+// labels and annotations added by others are kept. Returns nil if nothing changed.
+func mergeGenerated(current, desired *appv1.HelmRequest) *appv1.HelmRequest {
+	updated := current.DeepCopy()
+	for k, v := range desired.Labels {
+		if updated.Labels == nil {
+			updated.Labels = make(map[string]string)
+		}
+		updated.Labels[k] = v
+	}
+	for k, v := range desired.Annotations {
+		if updated.Annotations == nil {
+			updated.Annotations = make(map[string]string)
+		}
+		updated.Annotations[k] = v
+	}
+	updated.Spec = desired.Spec
+	if reflect.DeepEqual(updated.Labels, current.Labels) && reflect.DeepEqual(updated.Annotations, current.Annotations) &&
+		reflect.DeepEqual(updated.Spec, current.Spec) {
+		return nil
+	}
+	return updated
+}
+
+// deleteMembers deletes the members in the reverse order of their dependencies, the ones nothing depends on
+// first. The finalizer is removed after all of them are gone.
+func (r *HelmRequestBundleReconciler) deleteMembers(ctx context.Context, b *bundlev1alpha1.HelmRequestBundle, children []*appv1.HelmRequest) (ctrl.Result, error) {
+	if len(children) == 0 {
+		if controllerutil.ContainsFinalizer(b, util.FinalizerName) {
+			controllerutil.RemoveFinalizer(b, util.FinalizerName)
+			return ctrl.Result{}, r.Update(ctx, b)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	for _, hr := range bundle.Deletable(children) {
+		if !hr.DeletionTimestamp.IsZero() {
+			continue
+		}
+		r.Log.Info("delete member", "helmrequest", hr.Name)
+		if err := r.Delete(ctx, hr); err != nil && !isNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: bundleDeletionRecheckInterval}, r.updateStatus(ctx, b, b.Status.DeepCopy(), children)
+}
+
+// updateStatus aggregates the phases of the members into the status of the bundle, it's skipped if nothing
+// changed since original
+func (r *HelmRequestBundleReconciler) updateStatus(ctx context.Context, b *bundlev1alpha1.HelmRequestBundle,
+	original *bundlev1alpha1.HelmRequestBundleStatus, children []*appv1.HelmRequest) error {
+	status := bundle.Aggregate(b, children)
+	if reflect.DeepEqual(status, *original) {
+		return nil
+	}
+	b.Status = status
+	return r.Status().Update(ctx, b)
+}
+
+func (r *HelmRequestBundleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&bundlev1alpha1.HelmRequestBundle{}).
+		Owns(&appv1.HelmRequest{}).
+		Complete(r)
+}
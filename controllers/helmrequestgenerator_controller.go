@@ -0,0 +1,210 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/generator"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1beta1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// generatorResyncInterval is how often to regenerate, clusters and charts are not watched
+const generatorResyncInterval = 3 * time.Minute
+
+// HelmRequestGeneratorReconciler reconciles a HelmRequestGenerator object, it renders the template with the
+// parameters of the generators, and creates/updates/prunes the HelmRequests it owns. The HelmRequests are
+// deployed by the HelmRequest controller as usual.
+type HelmRequestGeneratorReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Sources are where the clusters come from, the same as the HelmRequest controller's
+	Sources []cluster.Source
+
+	// ChartRepoNamespace is where the ChartRepos live
+	ChartRepoNamespace string
+}
+
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequestgenerators,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequestgenerators/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=app.alauda.io,resources=helmrequests,verbs=get;list;watch;create;update;patch;delete
+
+func (r *HelmRequestGeneratorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("helmrequestgenerator", req.NamespacedName)
+
+	var g bundlev1alpha1.HelmRequestGenerator
+	if err := r.Get(ctx, req.NamespacedName, &g); err != nil {
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+	// the generated HelmRequests are deleted by the garbage collector
+	if !g.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	original := g.Status.DeepCopy()
+	desired, err := r.generate(ctx, &g)
+	if err != nil {
+		// the HelmRequests generated before are kept, a source may be unavailable temporarily
+		log.Error(err, "generate helmrequests error")
+		g.Status.Phase = bundlev1alpha1.HelmRequestGeneratorFailed
+		g.Status.Reason = err.Error()
+		return ctrl.Result{RequeueAfter: generatorResyncInterval}, r.updateStatus(ctx, &g, original)
+	}
+
+	var list appv1.HelmRequestList
+	if err := r.List(ctx, &list, client.InNamespace(g.Namespace), client.MatchingLabels{generator.GeneratorLabel: g.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	current := make(map[string]*appv1.HelmRequest)
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], &g) {
+			current[list.Items[i].Name] = &list.Items[i]
+		}
+	}
+
+	var names []string
+	for _, hr := range desired {
+		if err := r.apply(ctx, &g, hr); err != nil {
+			log.Error(err, "apply helmrequest error", "helmrequest", hr.Name)
+			return ctrl.Result{}, err
+		}
+		delete(current, hr.Name)
+		names = append(names, hr.Name)
+	}
+
+	// prune the ones disappeared from the generators
+	for _, hr := range current {
+		log.Info("prune helmrequest", "helmrequest", hr.Name)
+		if err := r.Delete(ctx, hr); err != nil && !isNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	g.Status.Phase = bundlev1alpha1.HelmRequestGeneratorReady
+	g.Status.ObservedGeneration = g.Generation
+	g.Status.Reason = ""
+	g.Status.HelmRequests = names
+	return ctrl.Result{RequeueAfter: generatorResyncInterval}, r.updateStatus(ctx, &g, original)
+}
+
+// generate renders the HelmRequests with the parameters from all the generators
+func (r *HelmRequestGeneratorReconciler) generate(ctx context.Context, g *bundlev1alpha1.HelmRequestGenerator) ([]*appv1.HelmRequest, error) {
+	var params []generator.Params
+	for _, item := range g.Spec.Generators {
+		switch {
+		case item.List != nil:
+			params = append(params, generator.ListParams(item.List)...)
+		case item.Clusters != nil:
+			clusters, err := cluster.ListFromSources(r.Sources)
+			if err != nil {
+				return nil, fmt.Errorf("list clusters error: %s", err.Error())
+			}
+			p, err := generator.ClusterParams(item.Clusters, clusters)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p...)
+		case item.Git != nil:
+			charts, err := r.listCharts(ctx, item.Git.ChartRepo)
+			if err != nil {
+				return nil, err
+			}
+			p, err := generator.ChartParams(item.Git, charts)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p...)
+		default:
+			return nil, fmt.Errorf("one of list, clusters and git is required in a generator")
+		}
+	}
+	return generator.Render(g, params)
+}
+
+// listCharts lists the charts discovered in a Git/SVN ChartRepo
+func (r *HelmRequestGeneratorReconciler) listCharts(ctx context.Context, name string) ([]v1beta1.Chart, error) {
+	var cr v1beta1.ChartRepo
+	if err := r.Get(ctx, types.NamespacedName{Namespace: r.ChartRepoNamespace, Name: name}, &cr); err != nil {
+		return nil, fmt.Errorf("get chartrepo %s error: %s", name, err.Error())
+	}
+	if cr.Spec.Type != string(v1beta1.ChartRepoGit) && cr.Spec.Type != string(v1beta1.ChartRepoSvn) {
+		return nil, fmt.Errorf("chartrepo %s is not a Git/SVN repo", name)
+	}
+
+	var charts v1beta1.ChartList
+	if err := r.List(ctx, &charts, client.InNamespace(r.ChartRepoNamespace), client.MatchingLabels{"repo": name}); err != nil {
+		return nil, err
+	}
+	return charts.Items, nil
+}
+
+// apply creates the HelmRequest, or updates it if the generated fields are changed
+func (r *HelmRequestGeneratorReconciler) apply(ctx context.Context, g *bundlev1alpha1.HelmRequestGenerator, desired *appv1.HelmRequest) error {
+	if err := controllerutil.SetControllerReference(g, desired, r.Scheme); err != nil {
+		return err
+	}
+
+	var current appv1.HelmRequest
+	err := r.Get(ctx, types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, &current)
+	if isNotFound(err) {
+		r.Log.Info("create helmrequest", "helmrequest", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(&current, g) {
+		return fmt.Errorf("helmrequest %s exists and is not generated by %s", desired.Name, g.Name)
+	}
+
+	updated := mergeGenerated(&current, desired)
+	if updated == nil {
+		return nil
+	}
+	r.Log.Info("update helmrequest", "helmrequest", desired.Name)
+	return r.Update(ctx, updated)
+}
+
+// updateStatus updates the status if it's changed since original
+func (r *HelmRequestGeneratorReconciler) updateStatus(ctx context.Context, g *bundlev1alpha1.HelmRequestGenerator,
+	original *bundlev1alpha1.HelmRequestGeneratorStatus) error {
+	if reflect.DeepEqual(g.Status, *original) {
+		return nil
+	}
+	return r.Status().Update(ctx, g)
+}
+
+func (r *HelmRequestGeneratorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&bundlev1alpha1.HelmRequestGenerator{}).
+		Owns(&appv1.HelmRequest{}).
+		Complete(r)
+}
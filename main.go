@@ -26,11 +26,13 @@ import (
 	"sync"
 	"time"
 
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
 	"github.com/alauda/captain/controllers"
 	"github.com/alauda/captain/pkg/chartrepo"
 	"github.com/alauda/captain/pkg/cluster"
 	"github.com/alauda/captain/pkg/config"
 	"github.com/alauda/captain/pkg/controller"
+	"github.com/alauda/captain/pkg/release"
 	"github.com/alauda/captain/pkg/webhook"
 	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
 	appv1alpha1 "github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
@@ -62,6 +64,8 @@ func init() {
 	_ = appv1beta1.AddToScheme(scheme)
 
 	_ = appv1.AddToScheme(scheme)
+
+	_ = bundlev1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -84,6 +88,17 @@ func main() {
 		o.Development = true
 	}))
 
+	// one-shot bulk import of the releases created by helm cli
+	if options.ImportHelmReleases != "" {
+		count, err := release.ImportHelmReleases(ctrl.GetConfigOrDie(), options.GetImportNamespace(), "")
+		if err != nil {
+			setupLog.Error(err, "import helm releases error", "imported", count)
+			os.Exit(1)
+		}
+		setupLog.Info("import helm releases done", "imported", count)
+		return
+	}
+
 	// this avoid slow list in controller.... does not know why, but it works.
 	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{})
 	if err != nil {
@@ -106,7 +121,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ChartRepoReconciler{
+	// set up signals so we handle the first shutdown signal gracefully
+	ctx := ctrl.SetupSignalHandler()
+
+	if options.AgentMode {
+		// the agent only deploy HelmRequests from the global cluster to the current one
+		if _, err := controller.NewAgent(mgr, &options, ctx); err != nil {
+			setupLog.Error(err, "create agent error")
+			os.Exit(1)
+		}
+	} else {
+		setupController(mgr, &options, cl, ctx)
+	}
+
+	// start pprof to debug memory usage
+	go func() {
+		http.ListenAndServe("0.0.0.0:6061", nil)
+	}()
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting manager")
+	if err := mgr.Start(ctx); err != nil {
+		setupLog.Error(err, "problem running manager")
+		os.Exit(1)
+	}
+}
+
+// setupController set up the ChartRepo reconciler, webhook and the HelmRequest controller for the global cluster
+func setupController(mgr ctrl.Manager, options *config.Options, cl client.Client, ctx context.Context) {
+	if err := (&controllers.ChartRepoReconciler{
 		Client:    cl,
 		Log:       ctrl.Log.WithName("controllers").WithName("ChartRepo"),
 		Scheme:    mgr.GetScheme(),
@@ -116,6 +167,14 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "ChartRepo")
 		os.Exit(1)
 	}
+	if err := (&controllers.HelmRequestBundleReconciler{
+		Client: cl,
+		Log:    ctrl.Log.WithName("controllers").WithName("HelmRequestBundle"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HelmRequestBundle")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	// legacy code....
@@ -143,7 +202,22 @@ func main() {
 	}
 
 	// add cluster refresher
-	cr := cluster.NewClusterRefresher(options.ClusterNamespace, mgr.GetConfig())
+	sources, err := cluster.NewSources(mgr.GetConfig(), options.GetClusterSources(), options.ClusterNamespace)
+	if err != nil {
+		setupLog.Error(err, "init cluster sources error")
+		os.Exit(1)
+	}
+	if err := (&controllers.HelmRequestGeneratorReconciler{
+		Client:             cl,
+		Log:                ctrl.Log.WithName("controllers").WithName("HelmRequestGenerator"),
+		Scheme:             mgr.GetScheme(),
+		Sources:            sources,
+		ChartRepoNamespace: options.ChartRepoNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HelmRequestGenerator")
+		os.Exit(1)
+	}
+	cr := cluster.NewClusterRefresher(sources)
 	if err := mgr.Add(cr); err != nil {
 		setupLog.Error(err, "add cluster refresher runner error")
 		os.Exit(1)
@@ -165,9 +239,7 @@ func main() {
 	}
 
 	// create controller
-	// set up signals so we handle the first shutdown signal gracefully
-	ctx := ctrl.SetupSignalHandler()
-	ctr, err := controller.NewController(mgr, &options, ctx)
+	ctr, err := controller.NewController(mgr, options, ctx)
 	if err != nil {
 		setupLog.Error(err, "create controller error")
 		os.Exit(1)
@@ -180,6 +252,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	// add release gc for each cluster
+	gcs, err := controller.NewReleaseGCs(ctr, options)
+	if err != nil {
+		setupLog.Error(err, "create release gc error")
+		os.Exit(1)
+	}
+	for _, gc := range gcs {
+		if err := mgr.Add(gc); err != nil {
+			setupLog.Error(err, "add release gc runner error")
+			os.Exit(1)
+		}
+	}
+
 	// add webhook
 	if options.EnableWebhook {
 		if err := webhook.RegisterHandlers(mgr); err != nil {
@@ -187,26 +272,6 @@ func main() {
 			os.Exit(1)
 		}
 	}
-
-	// start pprof to debug memory usage
-	go func() {
-		http.ListenAndServe("0.0.0.0:6061", nil)
-	}()
-
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
-	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
-	}
-
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctx); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
-	}
 }
 
 func createCerts(s *corev1.Secret) error {
@@ -0,0 +1,202 @@
+package bundle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/dependency"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// BundleLabel is the label of the generated HelmRequests, the value is the name of the bundle
+	BundleLabel = "captain.cpaas.io/bundle"
+	// MemberLabel is the label of the generated HelmRequests, the value is the name of the member
+	MemberLabel = "captain.cpaas.io/bundle-member"
+)
+
+// HelmRequestName returns the name of the HelmRequest generated for the member
+func HelmRequestName(b *bundlev1alpha1.HelmRequestBundle, member string) string {
+	return b.Name + "-" + member
+}
+
+// Render generates the HelmRequests of the members. The ordering between the members is translated to the
+// captain-dependencies annotation, so the HelmRequest controller syncs them in order. Invalid members and
+// cycles are errors.
+func Render(b *bundlev1alpha1.HelmRequestBundle) ([]*appv1.HelmRequest, error) {
+	names := make(map[string]bool)
+	for _, m := range b.Spec.Members {
+		if errs := validation.IsDNS1123Label(m.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid member name %q: %s", m.Name, strings.Join(errs, ","))
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("duplicated member %s", m.Name)
+		}
+		if m.Chart == "" {
+			return nil, fmt.Errorf("chart of member %s is required", m.Name)
+		}
+		names[m.Name] = true
+	}
+
+	var result []*appv1.HelmRequest
+	for _, m := range b.Spec.Members {
+		hr, err := renderMember(b, m, names)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, hr)
+	}
+
+	graph := dependency.NewGraph(result)
+	for _, hr := range result {
+		if cycle := graph.FindCycle(dependency.Key(hr.Namespace, hr.Name)); cycle != nil {
+			return nil, fmt.Errorf("members form a cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return result, nil
+}
+
+// renderMember generates the HelmRequest of one member, names are the members of the bundle
+func renderMember(b *bundlev1alpha1.HelmRequestBundle, m bundlev1alpha1.HelmRequestBundleMember, names map[string]bool) (*appv1.HelmRequest, error) {
+	annotations := make(map[string]string)
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+
+	var deps []string
+	if value := annotations[util.DependenciesAnnotation]; value != "" {
+		deps = append(deps, value)
+	}
+	for _, item := range m.DependsOn {
+		name, requirement := item, ""
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			name, requirement = item[:i], item[i:]
+		}
+		if !names[name] {
+			return nil, fmt.Errorf("member %s depends on unknown member %s", m.Name, name)
+		}
+		deps = append(deps, HelmRequestName(b, name)+requirement)
+	}
+	if len(deps) > 0 {
+		annotations[util.DependenciesAnnotation] = strings.Join(deps, ",")
+	}
+
+	values := helm.Values{}
+	if b.Spec.Values != nil {
+		values = helm.MergeValues(values, b.Spec.HelmValues.DeepCopy().Values)
+	}
+	if m.Values != nil {
+		values = helm.MergeValues(values, m.HelmValues.DeepCopy().Values)
+	}
+
+	hr := &appv1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      HelmRequestName(b, m.Name),
+			Namespace: b.Namespace,
+			Labels: map[string]string{
+				BundleLabel: b.Name,
+				MemberLabel: m.Name,
+			},
+			Annotations: annotations,
+		},
+		Spec: appv1.HelmRequestSpec{
+			ClusterName:          b.Spec.ClusterName,
+			InstallToAllClusters: b.Spec.InstallToAllClusters,
+			ReleaseName:          m.ReleaseName,
+			Chart:                m.Chart,
+			Version:              m.Version,
+			Namespace:            b.Spec.Namespace,
+		},
+	}
+	if m.ClusterName != "" {
+		hr.Spec.ClusterName = m.ClusterName
+	}
+	if m.Namespace != "" {
+		hr.Spec.Namespace = m.Namespace
+	}
+	for _, source := range append(append([]appv1.ValuesFromSource{}, b.Spec.ValuesFrom...), m.ValuesFrom...) {
+		hr.Spec.ValuesFrom = append(hr.Spec.ValuesFrom, *source.DeepCopy())
+	}
+	if len(values) > 0 {
+		hr.Spec.Values = values
+	}
+	// the member is deployed with the permissions of the bundle's creator
+	util.CopyCreator(b, hr)
+	if _, err := dependency.Parse(hr); err != nil {
+		return nil, fmt.Errorf("member %s: %s", m.Name, err.Error())
+	}
+	return hr, nil
+}
+
+// Deletable returns the HelmRequests no other HelmRequest in the list depends on, they are deleted first
+// when the bundle is deleted, so the members are deleted in the reverse order of their dependencies.
+func Deletable(hrs []*appv1.HelmRequest) []*appv1.HelmRequest {
+	graph := dependency.NewGraph(hrs)
+	var result []*appv1.HelmRequest
+	for _, hr := range hrs {
+		if len(graph.Dependents(dependency.Key(hr.Namespace, hr.Name))) == 0 {
+			result = append(result, hr)
+		}
+	}
+	return result
+}
+
+// Aggregate computes the status of the bundle from the HelmRequests of the members, it does not change the
+// ObservedGeneration and Reason
+func Aggregate(b *bundlev1alpha1.HelmRequestBundle, hrs []*appv1.HelmRequest) bundlev1alpha1.HelmRequestBundleStatus {
+	byMember := make(map[string]*appv1.HelmRequest)
+	for _, hr := range hrs {
+		byMember[hr.Labels[MemberLabel]] = hr
+	}
+
+	status := bundlev1alpha1.HelmRequestBundleStatus{
+		Phase:              bundlev1alpha1.HelmRequestBundleSynced,
+		ObservedGeneration: b.Status.ObservedGeneration,
+		Reason:             b.Status.Reason,
+	}
+	var failed, pending []string
+	for _, m := range b.Spec.Members {
+		item := bundlev1alpha1.HelmRequestBundleMemberStatus{
+			Name:        m.Name,
+			HelmRequest: HelmRequestName(b, m.Name),
+		}
+		if hr, ok := byMember[m.Name]; ok {
+			item.Phase = hr.Status.Phase
+			item.Reason = hr.Status.Reason
+		}
+		switch item.Phase {
+		case appv1.HelmRequestSynced:
+		case appv1.HelmRequestFailed:
+			failed = append(failed, m.Name)
+		default:
+			pending = append(pending, m.Name)
+		}
+		status.Members = append(status.Members, item)
+	}
+
+	if len(failed) > 0 {
+		status.Phase = bundlev1alpha1.HelmRequestBundleFailed
+	} else if len(pending) > 0 {
+		status.Phase = bundlev1alpha1.HelmRequestBundlePending
+	}
+	if b.DeletionTimestamp != nil {
+		status.Phase = bundlev1alpha1.HelmRequestBundleDeleting
+		status.Members = nil
+		for _, hr := range hrs {
+			status.Members = append(status.Members, bundlev1alpha1.HelmRequestBundleMemberStatus{
+				Name:        hr.Labels[MemberLabel],
+				HelmRequest: hr.Name,
+				Phase:       hr.Status.Phase,
+				Reason:      hr.Status.Reason,
+			})
+		}
+		sort.Slice(status.Members, func(i, j int) bool { return status.Members[i].Name < status.Members[j].Name })
+	}
+	return status
+}
@@ -0,0 +1,101 @@
+package bundle
+
+import (
+	"testing"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/chartutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBundle(members ...bundlev1alpha1.HelmRequestBundleMember) *bundlev1alpha1.HelmRequestBundle {
+	return &bundlev1alpha1.HelmRequestBundle{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "t1"},
+		Spec: bundlev1alpha1.HelmRequestBundleSpec{
+			Namespace:  "t1-apps",
+			HelmValues: appv1.HelmValues{Values: chartutil.Values{"global": map[string]interface{}{"tenant": "t1", "debug": false}}},
+			Members:    members,
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	b := newBundle(
+		bundlev1alpha1.HelmRequestBundleMember{
+			Name:        "db",
+			Chart:       "stable/mysql",
+			Annotations: map[string]string{util.CreatorAnnotation: "admin"},
+		},
+		bundlev1alpha1.HelmRequestBundleMember{
+			Name:       "api",
+			Chart:      "stable/api",
+			Namespace:  "t1-api",
+			DependsOn:  []string{"db:Ready"},
+			HelmValues: appv1.HelmValues{Values: chartutil.Values{"global": map[string]interface{}{"debug": true}}},
+		},
+	)
+	b.Annotations = map[string]string{util.CreatorAnnotation: "alice"}
+	hrs, err := Render(b)
+	assert.Nil(t, err)
+	assert.Len(t, 2, hrs)
+
+	// the members are deployed as the creator of the bundle
+	for _, hr := range hrs {
+		assert.Equal(t, "alice", hr.Annotations[util.CreatorAnnotation])
+	}
+
+	assert.Equal(t, "tenant-db", hrs[0].Name)
+	assert.Equal(t, "t1-apps", hrs[0].Spec.Namespace)
+	assert.Equal(t, "", hrs[0].Annotations[util.DependenciesAnnotation])
+
+	assert.Equal(t, "tenant-api", hrs[1].Name)
+	assert.Equal(t, "t1-api", hrs[1].Spec.Namespace)
+	assert.Equal(t, "api", hrs[1].Labels[MemberLabel])
+	assert.Equal(t, "tenant-db:Ready", hrs[1].Annotations[util.DependenciesAnnotation])
+	assert.Equal(t, map[string]interface{}{"tenant": "t1", "debug": true}, hrs[1].Spec.Values["global"])
+
+	// the shared values are not changed by the members
+	assert.Equal(t, false, b.Spec.Values["global"].(map[string]interface{})["debug"])
+}
+
+func TestRenderInvalid(t *testing.T) {
+	_, err := Render(newBundle(bundlev1alpha1.HelmRequestBundleMember{Name: "api", Chart: "stable/api", DependsOn: []string{"db"}}))
+	assert.NotNil(t, err)
+
+	_, err = Render(newBundle(
+		bundlev1alpha1.HelmRequestBundleMember{Name: "db", Chart: "stable/mysql", DependsOn: []string{"api"}},
+		bundlev1alpha1.HelmRequestBundleMember{Name: "api", Chart: "stable/api", DependsOn: []string{"db"}},
+	))
+	assert.NotNil(t, err)
+}
+
+func TestDeletableAndAggregate(t *testing.T) {
+	b := newBundle(
+		bundlev1alpha1.HelmRequestBundleMember{
+			Name:        "db",
+			Chart:       "stable/mysql",
+			Annotations: map[string]string{util.CreatorAnnotation: "admin"},
+		},
+		bundlev1alpha1.HelmRequestBundleMember{Name: "api", Chart: "stable/api", DependsOn: []string{"db"}},
+		bundlev1alpha1.HelmRequestBundleMember{Name: "web", Chart: "stable/web", DependsOn: []string{"api"}},
+	)
+	hrs, err := Render(b)
+	assert.Nil(t, err)
+
+	deletable := Deletable(hrs)
+	assert.Len(t, 1, deletable)
+	assert.Equal(t, "tenant-web", deletable[0].Name)
+
+	hrs[0].Status.Phase = appv1.HelmRequestSynced
+	hrs[1].Status.Phase = appv1.HelmRequestSynced
+	status := Aggregate(b, hrs[:2])
+	assert.Equal(t, bundlev1alpha1.HelmRequestBundlePending, status.Phase)
+	assert.Len(t, 3, status.Members)
+	assert.Equal(t, appv1.HelmRequestPhase(""), status.Members[2].Phase)
+
+	hrs[2].Status.Phase = appv1.HelmRequestFailed
+	assert.Equal(t, bundlev1alpha1.HelmRequestBundleFailed, Aggregate(b, hrs).Phase)
+}
@@ -2,7 +2,6 @@ package chartrepo
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/url"
 
@@ -71,6 +70,16 @@ func GetChartRepo(name string, ns string, cfg *rest.Config) (*repo.Entry, error)
 
 }
 
+// VersionNotFoundError means the Chart resource has no such version
+type VersionNotFoundError struct {
+	Chart   string
+	Version string
+}
+
+func (e *VersionNotFoundError) Error() string {
+	return fmt.Sprintf("cannot find version %s for chart %s", e.Version, e.Chart)
+}
+
 // GetChart get chart info, url and digest is the info we want
 func GetChart(name, version, ns string, cfg *rest.Config) (*repo.ChartVersion, error) {
 	client, err := clientset.NewForConfig(cfg)
@@ -88,6 +97,6 @@ func GetChart(name, version, ns string, cfg *rest.Config) (*repo.ChartVersion, e
 			return &item.ChartVersion, nil
 		}
 	}
-	return nil, errors.New(fmt.Sprintf("cannot find version %s for chart %s", version, name))
+	return nil, &VersionNotFoundError{Chart: name, Version: version}
 
 }
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/clusterregistry/apis/clusterregistry/v1alpha1"
+	clusterclientset "github.com/alauda/captain/pkg/clusterregistry/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// CRDSource discovers clusters from the clusterregistry Cluster resources. The admin token of each
+// cluster is stored in the secret referenced by .spec.authInfo.controller
+type CRDSource struct {
+	client     clusterclientset.Interface
+	kubeClient kubernetes.Interface
+	namespace  string
+}
+
+// NewCRDSource create a Cluster CRD source for namespace ns
+func NewCRDSource(cfg *rest.Config, ns string) (*CRDSource, error) {
+	client, err := clusterclientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &CRDSource{
+		client:     client,
+		kubeClient: kubeClient,
+		namespace:  ns,
+	}, nil
+}
+
+// Name implements Source
+func (s *CRDSource) Name() string {
+	return SourceCRD
+}
+
+// List implements Source. If the Cluster crd not exist, an empty list is returned
+func (s *CRDSource) List() ([]*Info, error) {
+	list, err := GetClusters(s.client, s.namespace, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var info []*Info
+	if list == nil {
+		return info, nil
+	}
+
+	for _, item := range list.Items {
+		i, err := s.parseClusterInfo(&item)
+		if err != nil {
+			klog.Error("parse cluster info error: ", item.GetName())
+		} else {
+			info = append(info, i)
+		}
+	}
+	return info, nil
+}
+
+// Get implements Source
+func (s *CRDSource) Get(name string) (*Info, error) {
+	cr, err := s.client.ClusterregistryV1alpha1().Clusters(s.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return s.parseClusterInfo(cr)
+}
+
+func (s *CRDSource) parseClusterInfo(cr *v1alpha1.Cluster) (*Info, error) {
+	var info Info
+	info.Name = cr.GetName()
+	info.Labels = cr.GetLabels()
+	info.Annotations = cr.GetAnnotations()
+
+	ns := cr.Spec.AuthInfo.Controller.Namespace
+	secretName := cr.Spec.AuthInfo.Controller.Name
+	// get token
+	sec, err := s.kubeClient.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := sec.Data["token"]
+	if ok {
+		// why is there a new line.
+		info.Token = strings.TrimSuffix(string(data), "\n")
+		info.Endpoint = selectEndpoint(&info, cr.Spec.KubernetesAPIEndpoints.ServerEndpoints)
+		return &info, nil
+	}
+	return nil, fmt.Errorf(" get token error for cluster: %s", cr.Name)
+}
@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alauda/captain/pkg/clusterregistry/apis/clusterregistry/v1alpha1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/klog"
+)
+
+// selectedEndpoints caches the reachable endpoint found for each cluster, so the endpoints are only probed
+// again after the chosen one is found unreachable. probing records the clusters being probed in background.
+var selectedEndpoints = struct {
+	sync.Mutex
+	items   map[string]string
+	probing map[string]bool
+}{items: map[string]string{}, probing: map[string]bool{}}
+
+// selectEndpoint choose the endpoint captain should use to access the cluster. The endpoints whose
+// ClientCIDR contains the pod ip are preferred, the rest are tried in order. The cluster info is parsed on
+// every sync, so the endpoints are not probed here: the cached one is returned if there is, otherwise the first
+// candidate is returned and the candidates are probed in background, the first reachable one is cached.
+func selectEndpoint(info *Info, eps []v1alpha1.ServerAddressByClientCIDR) string {
+	candidates := sortEndpointsByClientCIDR(eps, net.ParseIP(os.Getenv("MY_POD_IP")))
+	if len(candidates) == 0 {
+		return ""
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	selectedEndpoints.Lock()
+	defer selectedEndpoints.Unlock()
+	if cached, ok := selectedEndpoints.items[info.Name]; ok {
+		for _, ep := range candidates {
+			if ep == cached {
+				return ep
+			}
+		}
+	}
+	if !selectedEndpoints.probing[info.Name] {
+		selectedEndpoints.probing[info.Name] = true
+		go probeEndpoints(*info, candidates)
+	}
+	return candidates[0]
+}
+
+// probeEndpoints caches the first reachable endpoint of the candidates, nothing is cached if none of them is
+// reachable, they will be probed again next time. Each probe is bounded by reachableTimeout.
+func probeEndpoints(info Info, candidates []string) {
+	defer func() {
+		selectedEndpoints.Lock()
+		delete(selectedEndpoints.probing, info.Name)
+		selectedEndpoints.Unlock()
+	}()
+
+	for _, ep := range candidates {
+		info.Endpoint = ep
+		if info.IsReachable() {
+			selectedEndpoints.Lock()
+			selectedEndpoints.items[info.Name] = ep
+			selectedEndpoints.Unlock()
+			return
+		}
+	}
+	klog.Warningf("no reachable endpoint found for cluster %s, use %s", info.Name, candidates[0])
+}
+
+// forgetEndpoint drops the cached endpoint of the cluster if it's the failed one, the endpoints will be probed
+// again next time
+func forgetEndpoint(cluster, endpoint string) {
+	selectedEndpoints.Lock()
+	defer selectedEndpoints.Unlock()
+	if selectedEndpoints.items[cluster] == endpoint {
+		delete(selectedEndpoints.items, cluster)
+	}
+}
+
+// isConnectionError checks if the error means the endpoint can not be connected, other than the errors
+// returned by the apiserver
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// sortEndpointsByClientCIDR returns the server addresses, the ones whose ClientCIDR contains ip come first,
+// the most specific CIDR wins. The origin order is kept for the others.
+func sortEndpointsByClientCIDR(eps []v1alpha1.ServerAddressByClientCIDR, ip net.IP) []string {
+	type candidate struct {
+		address string
+		prefix  int
+	}
+
+	var matched []candidate
+	var others []string
+	for _, ep := range eps {
+		if ep.ServerAddress == "" {
+			continue
+		}
+		if ip != nil && ep.ClientCIDR != "" {
+			_, cidr, err := net.ParseCIDR(ep.ClientCIDR)
+			if err != nil {
+				klog.Warningf("invalid client cidr %s for endpoint %s: %s", ep.ClientCIDR, ep.ServerAddress, err.Error())
+			} else if cidr.Contains(ip) {
+				ones, _ := cidr.Mask.Size()
+				matched = append(matched, candidate{address: ep.ServerAddress, prefix: ones})
+				continue
+			}
+		}
+		others = append(others, ep.ServerAddress)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].prefix > matched[j].prefix
+	})
+
+	var result []string
+	for _, item := range matched {
+		result = append(result, item.address)
+	}
+	return append(result, others...)
+}
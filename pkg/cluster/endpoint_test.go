@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/clusterregistry/apis/clusterregistry/v1alpha1"
+	"github.com/gsamokovarov/assert"
+)
+
+func TestSortEndpointsByClientCIDR(t *testing.T) {
+	eps := []v1alpha1.ServerAddressByClientCIDR{
+		{ClientCIDR: "0.0.0.0/0", ServerAddress: "https://external:6443"},
+		{ClientCIDR: "10.0.0.0/8", ServerAddress: "https://internal:6443"},
+		{ClientCIDR: "192.168.0.0/16", ServerAddress: "https://office:6443"},
+	}
+
+	t.Run("most specific match first", func(t *testing.T) {
+		result := sortEndpointsByClientCIDR(eps, net.ParseIP("10.1.2.3"))
+		assert.Equal(t, []string{"https://internal:6443", "https://external:6443", "https://office:6443"}, result)
+	})
+
+	t.Run("no pod ip keeps order", func(t *testing.T) {
+		result := sortEndpointsByClientCIDR(eps, nil)
+		assert.Equal(t, []string{"https://external:6443", "https://internal:6443", "https://office:6443"}, result)
+	})
+}
+
+func TestSelectEndpointCached(t *testing.T) {
+	eps := []v1alpha1.ServerAddressByClientCIDR{
+		{ServerAddress: "https://external:6443"},
+		{ServerAddress: "https://internal:6443"},
+	}
+	info := &Info{Name: "cached"}
+
+	// the cached endpoint is used without probing
+	selectedEndpoints.items[info.Name] = "https://internal:6443"
+	assert.Equal(t, "https://internal:6443", selectEndpoint(info, eps))
+
+	forgetEndpoint(info.Name, "https://external:6443")
+	assert.Equal(t, "https://internal:6443", selectedEndpoints.items[info.Name])
+
+	forgetEndpoint(info.Name, "https://internal:6443")
+	_, ok := selectedEndpoints.items[info.Name]
+	assert.False(t, ok)
+}
+
+func TestSelectEndpointProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "19"}`)
+	}))
+	defer server.Close()
+	// nothing listens on the closed one
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	waitProbed := func(name string) {
+		for i := 0; i < 100; i++ {
+			selectedEndpoints.Lock()
+			probing := selectedEndpoints.probing[name]
+			selectedEndpoints.Unlock()
+			if !probing {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("probing %s not finished", name)
+	}
+	cachedOf := func(name string) (string, bool) {
+		selectedEndpoints.Lock()
+		defer selectedEndpoints.Unlock()
+		ep, ok := selectedEndpoints.items[name]
+		return ep, ok
+	}
+
+	// the first candidate is used while probing in background, the reachable one is used after
+	eps := []v1alpha1.ServerAddressByClientCIDR{{ServerAddress: closed.URL}, {ServerAddress: server.URL}}
+	info := &Info{Name: "probed"}
+	assert.Equal(t, closed.URL, selectEndpoint(info, eps))
+	waitProbed(info.Name)
+	assert.Equal(t, server.URL, selectEndpoint(info, eps))
+
+	// nothing is cached if no endpoint is reachable
+	info = &Info{Name: "unreachable"}
+	eps = []v1alpha1.ServerAddressByClientCIDR{{ServerAddress: closed.URL}, {ServerAddress: closed.URL + "/other"}}
+	assert.Equal(t, closed.URL, selectEndpoint(info, eps))
+	waitProbed(info.Name)
+	_, ok := cachedOf(info.Name)
+	assert.False(t, ok)
+}
+
+func TestForgetEndpointOnError(t *testing.T) {
+	info := &Info{Name: "failed", Endpoint: "https://internal:6443"}
+	selectedEndpoints.Lock()
+	selectedEndpoints.items[info.Name] = info.Endpoint
+	selectedEndpoints.Unlock()
+
+	// errors returned by the apiserver keep the endpoint
+	info.ForgetEndpointOnError(errors.New("admission webhook denied the request"))
+	_, ok := selectedEndpoints.items[info.Name]
+	assert.True(t, ok)
+
+	refused := &url.Error{Op: "Get", URL: info.Endpoint, Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	info.ForgetEndpointOnError(fmt.Errorf("sync release error: %w", refused))
+	_, ok = selectedEndpoints.items[info.Name]
+	assert.False(t, ok)
+}
@@ -1,10 +1,13 @@
 package cluster
 
 import (
+	"time"
+
 	"github.com/alauda/captain/pkg/clusterregistry/apis/clusterregistry/v1alpha1"
 	"github.com/alauda/captain/pkg/clusterregistry/client/clientset/versioned"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 )
@@ -12,6 +15,9 @@ import (
 const (
 	//DefaultClusterName is the cluster for the unspecified cluster
 	DefaultClusterName = "_default"
+
+	// reachableTimeout is the timeout used when probing a cluster endpoint
+	reachableTimeout = 5 * time.Second
 )
 
 //Info represents a Cluster,
@@ -25,6 +31,17 @@ type Info struct {
 
 	// Namespace the namespace which the chart will be installed to
 	Namespace string
+
+	// Labels are the labels of the Cluster resource or the kubeconfig Secret, used to select clusters
+	Labels map[string]string
+	// Annotations are the annotations of the Cluster resource or the kubeconfig Secret
+	Annotations map[string]string
+
+	// CAData/CertData/KeyData are optional tls data, usually comes from a kubeconfig. If CAData is empty,
+	// the server's certificate will not be verified
+	CAData   []byte
+	CertData []byte
+	KeyData  []byte
 }
 
 //GetContext is the context name for this cluster, this name format is generated from k8s code
@@ -38,11 +55,40 @@ func (i *Info) ToRestConfig() *rest.Config {
 		Host:        i.Endpoint,
 		BearerToken: i.Token,
 		TLSClientConfig: rest.TLSClientConfig{
-			Insecure: true,
+			Insecure: len(i.CAData) == 0,
+			CAData:   i.CAData,
+			CertData: i.CertData,
+			KeyData:  i.KeyData,
 		},
 	}
 }
 
+// IsReachable checks if the apiserver of this cluster can be accessed, by requesting it's version
+func (i *Info) IsReachable() bool {
+	cfg := i.ToRestConfig()
+	cfg.Timeout = reachableTimeout
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		klog.Warningf("init client for endpoint %s error: %s", i.Endpoint, err.Error())
+		return false
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		klog.Warningf("endpoint %s of cluster %s is not reachable: %s", i.Endpoint, i.Name, err.Error())
+		forgetEndpoint(i.Name, i.Endpoint)
+		return false
+	}
+	return true
+}
+
+// ForgetEndpointOnError drops the cached endpoint of the cluster if err means it can not be connected, so the
+// endpoints are probed again when the cluster info is parsed next time
+func (i *Info) ForgetEndpointOnError(err error) {
+	if isConnectionError(err) {
+		klog.Warningf("endpoint %s of cluster %s can not be connected: %s", i.Endpoint, i.Name, err.Error())
+		forgetEndpoint(i.Name, i.Endpoint)
+	}
+}
+
 //RestConfigToCluster generate a cluster Info from a rest config
 // This method and the Info.ToRestConfig both only support bearer token for now
 // luckily, the in-cluster rest config also use bearer token
@@ -50,6 +96,9 @@ func RestConfigToCluster(config *rest.Config, generatedName string) *Info {
 	var i Info
 	i.Token = config.BearerToken
 	i.Endpoint = config.Host
+	i.CAData = config.CAData
+	i.CertData = config.CertData
+	i.KeyData = config.KeyData
 	i.Name = generatedName
 	return &i
 }
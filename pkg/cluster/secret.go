@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+const (
+	// SecretTypeLabel is the label to mark a Secret as a cluster registration, it's value should be `cluster`
+	SecretTypeLabel = "captain.cpaas.io/secret-type"
+
+	// ClusterNameAnnotation specify the cluster name of a cluster Secret, default to the Secret's name
+	ClusterNameAnnotation = "captain.cpaas.io/cluster-name"
+
+	// kubeConfigKey is the key of kubeconfig in the Secret. `value` is used by Cluster API, we also support it.
+	kubeConfigKey         = "kubeconfig"
+	kubeConfigFallbackKey = "value"
+)
+
+// SecretSource discovers clusters from Secrets labeled with `captain.cpaas.io/secret-type: cluster`,
+// each of them contains a kubeconfig for a cluster.
+type SecretSource struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+}
+
+// NewSecretSource create a kubeconfig Secret source for namespace ns
+func NewSecretSource(cfg *rest.Config, ns string) (*SecretSource, error) {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretSource{
+		kubeClient: kubeClient,
+		namespace:  ns,
+	}, nil
+}
+
+// Name implements Source
+func (s *SecretSource) Name() string {
+	return SourceSecret
+}
+
+// List implements Source
+func (s *SecretSource) List() ([]*Info, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: kblabels.Set{SecretTypeLabel: "cluster"}.AsSelector().String(),
+	}
+	list, err := s.kubeClient.CoreV1().Secrets(s.namespace).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var info []*Info
+	for _, item := range list.Items {
+		i, err := parseClusterSecret(&item)
+		if err != nil {
+			klog.Errorf("parse cluster secret %s error: %s", item.GetName(), err.Error())
+			continue
+		}
+		info = append(info, i)
+	}
+	return info, nil
+}
+
+// Get implements Source. Because the cluster name may be different from the Secret's name, we have to
+// list them all.
+func (s *SecretSource) Get(name string) (*Info, error) {
+	list, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list {
+		if item.Name == name {
+			return item, nil
+		}
+	}
+	return nil, newNotFoundError(name)
+}
+
+// parseClusterSecret generate cluster info from the kubeconfig in a Secret, the current context is used.
+func parseClusterSecret(secret *corev1.Secret) (*Info, error) {
+	data, ok := secret.Data[kubeConfigKey]
+	if !ok {
+		data, ok = secret.Data[kubeConfigFallbackKey]
+	}
+	if !ok {
+		return nil, fmt.Errorf("no %s found in secret %s", kubeConfigKey, secret.GetName())
+	}
+
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	name := secret.GetName()
+	if secret.Annotations != nil && secret.Annotations[ClusterNameAnnotation] != "" {
+		name = secret.Annotations[ClusterNameAnnotation]
+	}
+
+	info := &Info{
+		Name:        name,
+		Labels:      secret.GetLabels(),
+		Annotations: secret.GetAnnotations(),
+		Endpoint:    cfg.Host,
+		Token:       cfg.BearerToken,
+		CertData:    cfg.CertData,
+		KeyData:     cfg.KeyData,
+	}
+	if !cfg.Insecure {
+		info.CAData = cfg.CAData
+	}
+	return info, nil
+}
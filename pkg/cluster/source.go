@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+const (
+	// SourceCRD discovers clusters from the clusterregistry Cluster resources
+	SourceCRD = "crd"
+
+	// SourceSecret discovers clusters from labeled Secrets which contains a kubeconfig
+	SourceSecret = "secret"
+)
+
+// Source is where captain discovers the clusters it can deploy to
+type Source interface {
+	// Name is the name of this source, as used in the --cluster-sources flag
+	Name() string
+
+	// List returns all the clusters in this source
+	List() ([]*Info, error)
+
+	// Get returns the cluster by name. If not exist, a NotFound error should be returned
+	Get(name string) (*Info, error)
+}
+
+// NewSources create cluster sources by name. All of them look up clusters in namespace ns.
+func NewSources(cfg *rest.Config, names []string, ns string) ([]Source, error) {
+	var sources []Source
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case SourceCRD:
+			s, err := NewCRDSource(cfg, ns)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, s)
+		case SourceSecret:
+			s, err := NewSecretSource(cfg, ns)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, s)
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown cluster source: %s", name)
+		}
+	}
+	return sources, nil
+}
+
+// ListFromSources merges clusters from all the sources. If the same cluster name exist in multiple sources,
+// the one from the former source wins.
+func ListFromSources(sources []Source) ([]*Info, error) {
+	var result []*Info
+	found := make(map[string]string)
+
+	for _, s := range sources {
+		list, err := s.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list {
+			if origin, ok := found[item.Name]; ok {
+				klog.Warningf("cluster %s from source %s is ignored, it already exist in source %s", item.Name, s.Name(), origin)
+				continue
+			}
+			found[item.Name] = s.Name()
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// GetFromSources get a cluster from the first source which contains it
+func GetFromSources(sources []Source, name string) (*Info, error) {
+	for _, s := range sources {
+		info, err := s.Get(name)
+		if err == nil {
+			return info, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, newNotFoundError(name)
+}
+
+func newNotFoundError(name string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Group: "clusterregistry.k8s.io", Resource: "clusters"}, name)
+}
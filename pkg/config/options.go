@@ -2,6 +2,8 @@ package config
 
 import (
 	"flag"
+	"strings"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
@@ -22,6 +24,11 @@ type Options struct {
 	// ClusterNamespace is the namespace where all the Cluster resources lives in
 	ClusterNamespace string
 
+	// ClusterSources is a comma separated list of where to discover clusters from, supported sources are:
+	// crd: the clusterregistry Cluster resources
+	// secret: Secrets labeled with captain.cpaas.io/secret-type=cluster, which contains a kubeconfig
+	ClusterSources string
+
 	// ChartRepoNamespace is the namespace where all the ChartRepo resources lives in
 	ChartRepoNamespace string
 
@@ -41,6 +48,50 @@ type Options struct {
 
 	// PrintVersion print the version and exist
 	PrintVersion bool
+
+	// AgentMode runs captain as an agent in a member cluster. The agent pulls HelmRequests targeting this
+	// cluster from the global cluster, deploy them locally and report status back. This is used for clusters
+	// the global cluster cannot reach.
+	AgentMode bool
+
+	// AgentClusterName is the name of the cluster the agent runs in, as it's referred in the HelmRequests
+	AgentClusterName string
+
+	// ImportHelmReleases imports all the release records created by helm cli into Release CRs, and exit.
+	// It's value is the namespace to import from, "all" means all the namespaces.
+	ImportHelmReleases string
+
+	// ReleaseGCPolicy decides what to do with the orphaned releases and the releases stuck in pending statuses:
+	// none: disable release gc
+	// report: send events for them
+	// clean: uninstall the orphaned releases, delete the stuck release records
+	ReleaseGCPolicy string
+
+	// ReleaseGCInterval is the interval of release gc
+	ReleaseGCInterval time.Duration
+
+	// PendingReleaseTimeout is how long a release can stay in pending statuses before it's seen as stuck
+	PendingReleaseTimeout time.Duration
+
+	// GlobalKubeConfig is the path of the kubeconfig file used by the agent to access the global cluster
+	GlobalKubeConfig string
+
+	// AllowUncheckedHelmRequests allows the HelmRequests without a creator recorded by the webhook to deploy with
+	// captain's own permissions. By default they fail to sync.
+	AllowUncheckedHelmRequests bool
+}
+
+// GetImportNamespace returns the namespace to import helm releases from, empty means all the namespaces
+func (opt *Options) GetImportNamespace() string {
+	if opt.ImportHelmReleases == "all" {
+		return ""
+	}
+	return opt.ImportHelmReleases
+}
+
+// GetClusterSources returns the enabled cluster sources
+func (opt *Options) GetClusterSources() []string {
+	return strings.Split(opt.ClusterSources, ",")
 }
 
 func (opt *Options) setDefaults() {
@@ -61,6 +112,8 @@ func (opt *Options) BindFlags() {
 		"Install HelmRequest CRD if it does not exist")
 	flag.StringVar(&opt.ClusterNamespace, "cluster-namespace", "captain",
 		"The namespace where all the Cluster resource lives in")
+	flag.StringVar(&opt.ClusterSources, "cluster-sources", "crd,secret",
+		"Comma separated list of cluster sources to discover clusters from, supported: crd, secret")
 	flag.StringVar(&opt.ChartRepoNamespace, "chartrepo-namespace", "captain",
 		"The namespace where all the ChartRepo resource lives in")
 	flag.StringVar(&opt.GlobalClusterName, "global-cluster-name", "global",
@@ -79,6 +132,26 @@ func (opt *Options) BindFlags() {
 	flag.BoolVar(&opt.InstallStableRepo, "install-stable-repo", true,
 		"Install helm stable repo")
 
+	flag.BoolVar(&opt.AgentMode, "agent", false,
+		"Run as an agent in a member cluster, pull HelmRequests from the global cluster and deploy them locally")
+	flag.StringVar(&opt.AgentClusterName, "agent-cluster-name", "",
+		"The name of the cluster the agent runs in")
+	flag.StringVar(&opt.GlobalKubeConfig, "global-kubeconfig", "",
+		"Path to the kubeconfig used by the agent to access the global cluster")
+
+	flag.BoolVar(&opt.AllowUncheckedHelmRequests, "allow-unchecked-helmrequests", false,
+		"Allow the HelmRequests without a recorded creator to deploy without permission checks")
+
+	flag.StringVar(&opt.ReleaseGCPolicy, "release-gc-policy", "report",
+		"What to do with the orphaned and stuck releases, supported: none, report, clean")
+	flag.DurationVar(&opt.ReleaseGCInterval, "release-gc-interval", 30*time.Minute,
+		"The interval of release gc")
+	flag.DurationVar(&opt.PendingReleaseTimeout, "pending-release-timeout", 30*time.Minute,
+		"How long a release can stay in pending-install/pending-upgrade/pending-rollback/uninstalling before it's seen as stuck")
+
+	flag.StringVar(&opt.ImportHelmReleases, "import-helm-releases", "",
+		"Import all the releases created by helm cli in the namespace (\"all\" for all namespaces) to Release CRs, then exit")
+
 	// flag.StringVar(&opt.MetricsBindAddress, "old-metrics-bind-address", ":6060",
 	//	"Setup bind address for metrics server, use \"\" to disable it")
 
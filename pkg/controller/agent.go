@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/config"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	informers "github.com/alauda/helm-crds/pkg/client/informers/externalversions"
+	listers "github.com/alauda/helm-crds/pkg/client/listers/app/v1alpha1"
+	commoncache "github.com/patrickmn/go-cache"
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Agent runs in a member cluster which the global cluster cannot reach (for example, behind NAT). It watches
+// the HelmRequests in the global cluster, deploy the ones targeting this cluster locally and report the status
+// back. This is the cluster watch in reverse: the global cluster is the "remote" one.
+// Only HelmRequests with annotation `captain-agent-managed: "true"` are handled by agents.
+type Agent struct {
+	// Controller is configured with the global cluster as it's current cluster, so HelmRequests, events,
+	// valuesFrom and ChartRepos are all from the global cluster.
+	*Controller
+
+	// clusterName is the name of the cluster this agent runs in
+	clusterName string
+
+	// local is the cluster info of the cluster this agent runs in
+	local *cluster.Info
+
+	// localClient is used to access the Release resources in the local cluster
+	localClient clientset.Interface
+
+	// inCluster indicate if the agent runs in-cluster, used to enable leader election
+	inCluster bool
+
+	// deploy installs/upgrades the release in the local cluster, it's the controller's syncRelease
+	deploy func(info *cluster.Info, hr *appv1.HelmRequest, client clientset.Interface) error
+}
+
+// NewAgent create a new agent and add it to the manager. mgr is the manager of the local cluster
+func NewAgent(mgr manager.Manager, opt *config.Options, ctx context.Context) (*Agent, error) {
+	if opt.AgentClusterName == "" {
+		return nil, fmt.Errorf("--agent-cluster-name is required in agent mode")
+	}
+
+	globalCfg, err := clientcmd.BuildConfigFromFlags("", opt.GlobalKubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(globalCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	appClient, err := clientset.NewForConfig(globalCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	localCfg := mgr.GetConfig()
+	localClient, err := clientset.NewForConfig(localCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	appInformerFactory := informers.NewSharedInformerFactory(appClient, time.Second*30)
+	informer := appInformerFactory.App().V1alpha1().HelmRequests()
+	if err := informer.Informer().AddIndexers(cache.Indexers{dependencyIndex: dependencyIndexFunc}); err != nil {
+		return nil, err
+	}
+
+	agent := &Agent{
+		Controller: &Controller{
+			kubeClient:   kubeClient,
+			appClientSet: appClient,
+			clusterConfig: clusterConfig{
+				clusterNamespace:  opt.ClusterNamespace,
+				globalClusterName: opt.GlobalClusterName,
+			},
+			systemNamespace:    opt.ChartRepoNamespace,
+			allowUnchecked:     opt.AllowUncheckedHelmRequests,
+			restConfig:         globalCfg,
+			recorder:           newEventRecorder(opt.AgentClusterName, kubeClient),
+			helmRequestLister:  informer.Lister(),
+			helmRequestSynced:  informer.Informer().HasSynced,
+			helmRequestIndexer: informer.Informer().GetIndexer(),
+			workQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "AgentHelmRequests"),
+			ClusterCache:       commoncache.New(1*time.Minute, 5*time.Minute),
+
+			clusterHelmRequestListers:  make(map[string]listers.HelmRequestLister),
+			clusterHelmRequestSynced:   make(map[string]cache.InformerSynced),
+			clusterHelmRequestIndexers: make(map[string]cache.Indexer),
+			clusterWorkQueues:          make(map[string]workqueue.RateLimitingInterface),
+			clusterClients:             make(map[string]clientset.Interface),
+			clusterRecorders:           make(map[string]record.EventRecorder),
+			retries:                    make(map[string]*retryState),
+
+			stopCh: ctx.Done(),
+		},
+		clusterName: opt.AgentClusterName,
+		local:       cluster.RestConfigToCluster(localCfg, opt.AgentClusterName),
+		localClient: localClient,
+		inCluster:   localCfg.BearerTokenFile != "",
+	}
+	agent.deploy = agent.syncRelease
+
+	klog.Infof("Setting up event handlers for agent of cluster %s", agent.clusterName)
+	informer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			hr, ok := obj.(*appv1.HelmRequest)
+			if !ok {
+				converted, err := convertToV1(obj)
+				if err != nil {
+					return false
+				}
+				hr = converted
+			}
+			return agent.isTarget(hr)
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: agent.enqueueHelmRequest,
+			UpdateFunc: func(old, new interface{}) {
+				agent.enqueueHelmRequest(new)
+			},
+		},
+	})
+
+	appInformerFactory.Start(ctx.Done())
+
+	return agent, mgr.Add(agent)
+}
+
+// isTarget check if a HelmRequest should be deployed by this agent
+func (a *Agent) isTarget(hr *appv1.HelmRequest) bool {
+	if !isAgentManaged(hr) {
+		return false
+	}
+	return hr.Spec.InstallToAllClusters || hr.Spec.ClusterName == a.clusterName
+}
+
+// NeedLeaderElection enable leader election if the agent runs in-cluster
+func (a *Agent) NeedLeaderElection() bool {
+	return a.inCluster
+}
+
+// Start runs the agent workers until ctx is done
+func (a *Agent) Start(ctx context.Context) error {
+	defer utilruntime.HandleCrash()
+	defer a.workQueue.ShutDown()
+
+	klog.Infof("Starting HelmRequest agent for cluster %s", a.clusterName)
+
+	klog.Info("Waiting for informer caches of global cluster to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), a.helmRequestSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	klog.Info("Starting agent workers")
+	go wait.Until(a.runWorker, time.Second, ctx.Done())
+
+	<-ctx.Done()
+	klog.Info("Shutting down agent workers")
+	return nil
+}
+
+func (a *Agent) runWorker() {
+	for a.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem works the same as the controller's, except it calls the agent's syncHandler
+func (a *Agent) processNextWorkItem() bool {
+	obj, shutdown := a.workQueue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer a.workQueue.Done(obj)
+		key, ok := obj.(string)
+		if !ok {
+			a.workQueue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("expected string in workQueue but got %#v", obj))
+			return nil
+		}
+		if err := a.syncHandler(key); err != nil {
+			a.workQueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		}
+		a.workQueue.Forget(obj)
+		klog.Infof("Successfully synced '%s' to cluster %s", key, a.clusterName)
+		return nil
+	}(obj)
+
+	if err != nil {
+		utilruntime.HandleError(err)
+	}
+	return true
+}
+
+// syncHandler deploy the HelmRequest in global cluster to the local cluster, and update it's status
+func (a *Agent) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	hr, err := a.appClientSet.AppV1().HelmRequests(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("helmRequest '%s' in work queue no longer exists", key))
+			return nil
+		}
+		return err
+	}
+
+	if !a.isTarget(hr) || isSwitchEnabled(hr, util.NoSyncAnotation) {
+		return nil
+	}
+
+	if !hr.DeletionTimestamp.IsZero() {
+		klog.Infof("HelmRequest has not nil DeletionTimestamp, starting to delete it from cluster %s: %s", a.clusterName, hr.Name)
+		if err := a.deleteLocal(hr); err != nil {
+			a.sendFailedDeleteEvent(hr, err)
+			return err
+		}
+		return nil
+	}
+
+	if err := a.addFinalizer(hr); err != nil {
+		a.sendFailedSyncEvent(hr, err)
+		return err
+	}
+
+	if err := a.checkDependencies(hr); err != nil {
+		klog.Infof("check dependencies for %s not pass, err is : %+v", hr.Name, err)
+		a.sendFailedSyncEvent(hr, err)
+		return err
+	}
+
+	if a.isSynced(hr) {
+		klog.Infof("HelmRequest %s synced to cluster %s", hr.Name, a.clusterName)
+		return nil
+	}
+
+	if a.isStalled(hr, key) {
+		klog.Infof("HelmRequest %s is stalled, skip sync", hr.Name)
+		return nil
+	}
+
+	if !hr.Spec.InstallToAllClusters {
+		a.setPendingStatus(hr)
+	}
+
+	if err := a.deploy(a.local, hr, a.localClient); err != nil {
+		return a.retrySync(hr, "", key, err)
+	}
+
+	if err := a.updateSyncedStatus(hr); err != nil {
+		return err
+	}
+	a.resetRetries(hr, key)
+
+	a.recorder.Event(hr, corev1.EventTypeNormal, SuccessSynced,
+		fmt.Sprintf("HelmRequest synced to cluster %s by agent", a.clusterName))
+	return nil
+}
+
+// isSynced check if the HelmRequest has been synced to the local cluster
+func (a *Agent) isSynced(hr *appv1.HelmRequest) bool {
+	if !helm.IsHelmRequestSynced(hr) {
+		return false
+	}
+	if hr.Spec.InstallToAllClusters {
+		return funk.ContainsString(hr.Status.SyncedClusters, a.clusterName)
+	}
+	return hr.Status.Phase == appv1.HelmRequestSynced
+}
+
+// updateSyncedStatus report the sync result back to global cluster. For InstallToAllClusters, the synced
+// clusters are reset by the first agent who sync the new spec, and others append to it.
+func (a *Agent) updateSyncedStatus(hr *appv1.HelmRequest) error {
+	if !hr.Spec.InstallToAllClusters {
+		return a.updateHelmRequestSynced(hr)
+	}
+
+	client := a.getAppClient(hr)
+	origin, err := client.AppV1().HelmRequests(hr.Namespace).Get(hr.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	h := helm.GenUniqueHash(origin)
+	request := origin.DeepCopy()
+	if request.Status.LastSpecHash != h {
+		request.Status.SyncedClusters = nil
+	}
+	if !funk.ContainsString(request.Status.SyncedClusters, a.clusterName) {
+		request.Status.SyncedClusters = append(request.Status.SyncedClusters, a.clusterName)
+	}
+	request.Status.LastSpecHash = h
+	request.Status.Reason = ""
+	request.Status.Phase = appv1.HelmRequestSynced
+	request.Status.Version = hr.Status.Version
+	request.Status.Notes = hr.Status.Notes
+	return helm.UpdateHelmRequestStatus(client, request)
+}
+
+// checkDependencies checks the dependencies in the global cluster have been synced to this cluster
+func (a *Agent) checkDependencies(hr *appv1.HelmRequest) error {
+	for _, name := range hr.Spec.Dependencies {
+		dep, err := a.appClientSet.AppV1().HelmRequests(hr.GetNamespace()).Get(name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Retrieve dependency %s for %s error: %s", name, hr.GetName(), err.Error())
+			return err
+		}
+		if !dep.IsClusterSynced(a.clusterName) {
+			return fmt.Errorf("dependency %s of %s is not synced to cluster %s yet", dep.Name, hr.Name, a.clusterName)
+		}
+	}
+	return nil
+}
+
+// deleteLocal uninstall the release from local cluster. The finalizer is removed when no cluster remains synced.
+func (a *Agent) deleteLocal(hr *appv1.HelmRequest) error {
+	ci := *a.local
+	ci.Namespace = hr.GetReleaseNamespace()
+
+	d := helm.NewDeploy(a.appClientSet)
+	d.HelmRequest = hr
+	d.Cluster = &ci
+	d.AllowUnchecked = a.allowUnchecked
+	if err := d.Delete(); err != nil {
+		return err
+	}
+	a.recorder.Event(hr, corev1.EventTypeNormal, SuccessfulDelete,
+		fmt.Sprintf("Deleted release %s from cluster %s", hr.GetReleaseName(), a.clusterName))
+
+	if !hr.Spec.InstallToAllClusters {
+		return a.removeFinalizer(hr)
+	}
+
+	request := hr.DeepCopy()
+	request.Status.SyncedClusters = nil
+	for _, name := range hr.Status.SyncedClusters {
+		if name != a.clusterName {
+			request.Status.SyncedClusters = append(request.Status.SyncedClusters, name)
+		}
+	}
+	if len(request.Status.SyncedClusters) > 0 {
+		return helm.UpdateHelmRequestStatus(a.appClientSet, request)
+	}
+	return a.removeFinalizer(hr)
+}
+
+// isAgentManaged check if a HelmRequest is deployed by captain agents instead of the global captain
+func isAgentManaged(hr *appv1.HelmRequest) bool {
+	return isSwitchEnabled(hr, util.AgentManagedAnnotation)
+}
+
+// isSwitchEnabled return annoKey Annotation is true or not
+func isSwitchEnabled(hr *appv1.HelmRequest, annoKey string) bool {
+	if hr == nil || len(hr.Annotations) == 0 {
+		return false
+	}
+	return hr.Annotations[annoKey] == "true"
+}
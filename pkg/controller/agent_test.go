@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAgentIsTarget(t *testing.T) {
+	a := &Agent{clusterName: "business"}
+	managed := map[string]string{util.AgentManagedAnnotation: "true"}
+
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		spec        appv1.HelmRequestSpec
+		expected    bool
+	}{
+		{"managed, this cluster", managed, appv1.HelmRequestSpec{ClusterName: "business"}, true},
+		{"managed, all clusters", managed, appv1.HelmRequestSpec{InstallToAllClusters: true}, true},
+		{"managed, another cluster", managed, appv1.HelmRequestSpec{ClusterName: "other"}, false},
+		{"managed, global cluster", managed, appv1.HelmRequestSpec{}, false},
+		{"not managed", nil, appv1.HelmRequestSpec{ClusterName: "business"}, false},
+		{"disabled", map[string]string{util.AgentManagedAnnotation: "false"}, appv1.HelmRequestSpec{InstallToAllClusters: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hr := newHelmRequest(tc.annotations)
+			hr.Spec = tc.spec
+			assert.Equal(t, tc.expected, a.isTarget(hr))
+		})
+	}
+}
+
+func TestAgentSyncHandler(t *testing.T) {
+	const key = "default/test"
+	newAgent := func(hr *appv1.HelmRequest, deployErr error) (*Agent, *[]string) {
+		var deployed []string
+		a := &Agent{
+			Controller:  newTestController(hr),
+			clusterName: "business",
+			local:       &cluster.Info{Name: "business"},
+			localClient: fake.NewSimpleClientset(),
+		}
+		a.deploy = func(info *cluster.Info, hr *appv1.HelmRequest, client clientset.Interface) error {
+			// the release is deployed to the local cluster, the release records are kept there as well
+			assert.Equal(t, a.local, info)
+			assert.Equal(t, a.localClient, client)
+			deployed = append(deployed, hr.Name)
+			return deployErr
+		}
+		return a, &deployed
+	}
+	getHelmRequest := func(a *Agent) *appv1.HelmRequest {
+		hr, err := a.appClientSet.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+		assert.Nil(t, err)
+		return hr
+	}
+
+	// the HelmRequest in the global cluster is deployed locally, the status is reported back
+	hr := newHelmRequest(map[string]string{util.AgentManagedAnnotation: "true"})
+	hr.Spec.ClusterName = "business"
+	a, deployed := newAgent(hr, nil)
+	assert.Nil(t, a.syncHandler(key))
+	assert.Equal(t, []string{"test"}, *deployed)
+	synced := getHelmRequest(a)
+	assert.Equal(t, appv1.HelmRequestSynced, synced.Status.Phase)
+	assert.Equal(t, helm.GenUniqueHash(synced), synced.Status.LastSpecHash)
+	assert.Equal(t, []string{util.FinalizerName}, synced.Finalizers)
+	// not deployed again until it's changed
+	assert.Nil(t, a.syncHandler(key))
+	assert.Len(t, 1, *deployed)
+
+	// for installToAllClusters, this cluster is appended to the synced ones
+	hr = newHelmRequest(map[string]string{util.AgentManagedAnnotation: "true"})
+	hr.Spec.InstallToAllClusters = true
+	hr.Status.LastSpecHash = helm.GenUniqueHash(hr)
+	hr.Status.SyncedClusters = []string{"other"}
+	a, deployed = newAgent(hr, nil)
+	assert.Nil(t, a.syncHandler(key))
+	assert.Len(t, 1, *deployed)
+	assert.Equal(t, []string{"other", "business"}, getHelmRequest(a).Status.SyncedClusters)
+
+	// a failed deploy is reported and retried
+	hr = newHelmRequest(map[string]string{util.AgentManagedAnnotation: "true"})
+	hr.Spec.ClusterName = "business"
+	a, deployed = newAgent(hr, errors.New("connection refused"))
+	assert.Nil(t, a.syncHandler(key))
+	assert.Len(t, 1, *deployed)
+	failed := getHelmRequest(a)
+	assert.Equal(t, appv1.HelmRequestFailed, failed.Status.Phase)
+	assert.Equal(t, "connection refused", failed.Status.Reason)
+	assert.True(t, isConditionTrue(failed, ConditionRetries))
+
+	// the ones targeting other clusters are left to their agents
+	hr = newHelmRequest(map[string]string{util.AgentManagedAnnotation: "true"})
+	hr.Spec.ClusterName = "other"
+	a, deployed = newAgent(hr, nil)
+	assert.Nil(t, a.syncHandler(key))
+	assert.Len(t, 0, *deployed)
+	assert.Len(t, 0, getHelmRequest(a).Finalizers)
+}
+
+func TestControllerSkipsAgentManaged(t *testing.T) {
+	hr := newHelmRequest(map[string]string{util.AgentManagedAnnotation: "true"})
+	hr.Spec.ClusterName = "business"
+	c := newTestController(hr)
+
+	assert.Nil(t, c.syncHandler("default/test"))
+	skipped, err := c.appClientSet.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, 0, skipped.Finalizers)
+	assert.Equal(t, hr.Status, skipped.Status)
+}
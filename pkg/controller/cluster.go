@@ -1,14 +1,14 @@
 package controller
 
 import (
-	"context"
 	"fmt"
-	"strings"
 
 	"github.com/alauda/captain/pkg/cluster"
-	"github.com/alauda/captain/pkg/clusterregistry/apis/clusterregistry/v1alpha1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/alauda/captain/pkg/helm"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog"
 )
 
@@ -17,7 +17,7 @@ var (
 	allClustersCacheKey = "_all"
 )
 
-// getAllClusters list all the Clusters in global and cache it
+// getAllClusters list all the Clusters from the cluster sources and cache it
 func (c *Controller) getAllClusters() ([]*cluster.Info, error) {
 	result, ok := c.ClusterCache.Get(allClustersCacheKey)
 	if ok {
@@ -25,28 +25,9 @@ func (c *Controller) getAllClusters() ([]*cluster.Info, error) {
 	}
 	klog.Infof("refresh cluster list from namespace: %s", c.clusterConfig.clusterNamespace)
 
-	opts := v1.ListOptions{}
-	var info []*cluster.Info
-
-	list, err := c.GetClusterClient().ClusterregistryV1alpha1().Clusters(c.clusterConfig.clusterNamespace).List(opts)
+	info, err := cluster.ListFromSources(c.clusterConfig.sources)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			klog.Warning("No cluster crd found, disable multi cluster support", err)
-			return info, nil
-
-		} else {
-			return nil, err
-		}
-
-	}
-
-	for _, item := range list.Items {
-		i, err := c.parseClusterInfo(&item)
-		if err != nil {
-			klog.Error("parse cluster info error: ", item.GetName())
-		} else {
-			info = append(info, i)
-		}
+		return nil, err
 	}
 	klog.Infof("fetch %d clusters", len(info))
 
@@ -54,31 +35,6 @@ func (c *Controller) getAllClusters() ([]*cluster.Info, error) {
 	return info, nil
 }
 
-func (c *Controller) parseClusterInfo(cr *v1alpha1.Cluster) (*cluster.Info, error) {
-	var info cluster.Info
-	info.Name = cr.GetName()
-	eps := cr.Spec.KubernetesAPIEndpoints.ServerEndpoints
-	if len(eps) > 0 {
-		info.Endpoint = eps[0].ServerAddress
-	}
-
-	ns := cr.Spec.AuthInfo.Controller.Namespace
-	secretName := cr.Spec.AuthInfo.Controller.Name
-	// get token
-	sec, err := c.kubeClient.CoreV1().Secrets(ns).Get(context.Background(), secretName, v1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	data, ok := sec.Data["token"]
-	if ok {
-		// why is there a new line.
-		info.Token = strings.TrimSuffix(string(data), "\n")
-		return &info, nil
-	}
-	return nil, fmt.Errorf(" get token error for cluster: %s", cr.Name)
-}
-
 // getClusterInfo get info about one single cluster.
 // if name is "", return current cluster info
 func (c *Controller) getClusterInfo(name string) (*cluster.Info, error) {
@@ -94,14 +50,67 @@ func (c *Controller) getClusterInfo(name string) (*cluster.Info, error) {
 
 	klog.Infof("refresh cluster data: %s", name)
 
-	options := v1.GetOptions{}
-	cr, err := c.GetClusterClient().ClusterregistryV1alpha1().Clusters(c.clusterConfig.clusterNamespace).Get(name, options)
-	if err != nil {
-		return nil, err
-	}
-	info, err := c.parseClusterInfo(cr)
+	info, err := cluster.GetFromSources(c.clusterConfig.sources, name)
 	if err == nil {
 		c.ClusterCache.SetDefault(name, info)
 	}
 	return info, err
 }
+
+// clusterNames returns the names of the clusters
+func clusterNames(clusters []*cluster.Info) []string {
+	var names []string
+	for _, item := range clusters {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+// removeUntargetedClusters uninstalls the release from the clusters in .status.syncedClusters which are not
+// in targets any more (eg: the cluster was deleted and came back).
+// Clusters that no longer exist are pruned, and the ones unreachable or failed to uninstall are returned to
+// be retried later.
+func (c *Controller) removeUntargetedClusters(hr *appv1.HelmRequest, targets []string) []string {
+	var lingering []string
+	for _, name := range hr.Status.SyncedClusters {
+		if funk.ContainsString(targets, name) {
+			continue
+		}
+
+		info, err := c.getClusterInfo(name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				klog.Infof("cluster %s of helmrequest %s no longer exists, prune it", name, hr.Name)
+				c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, ClusterPruned,
+					fmt.Sprintf("Cluster %s no longer exists, removed it from synced clusters", name))
+				continue
+			}
+			klog.Errorf("get cluster info of %s error: %s", name, err.Error())
+			lingering = append(lingering, name)
+			continue
+		}
+		if !info.IsReachable() {
+			lingering = append(lingering, name)
+			continue
+		}
+
+		ci := *info
+		ci.Namespace = hr.GetReleaseNamespace()
+		d := helm.NewDeploy(c.getAppClient(hr))
+		d.HelmRequest = hr
+		d.Cluster = &ci
+		d.AllowUnchecked = c.allowUnchecked
+		if err := d.Delete(); err != nil {
+			lingering = append(lingering, name)
+			if helm.IsWaitingForDeletion(err) {
+				continue
+			}
+			c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, FailedDelete,
+				fmt.Sprintf("Uninstall from cluster %s which is not a target any more error: %s", name, err.Error()))
+			continue
+		}
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, UninstalledFromCluster,
+			fmt.Sprintf("Uninstalled from cluster %s which is not a target any more", name))
+	}
+	return lingering
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/gsamokovarov/assert"
+	commoncache "github.com/patrickmn/go-cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRemoveUntargetedClusters(t *testing.T) {
+	// the releases are stored in memory, so uninstalling from the reachable cluster finds nothing to delete
+	os.Setenv("HELM_DRIVER", "memory")
+	defer os.Unsetenv("HELM_DRIVER")
+
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "19"}`)
+	}))
+	defer apiserver.Close()
+	// nothing listens on the closed one
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	hr := newHelmRequest(nil)
+	hr.Spec.InstallToAllClusters = true
+	hr.Status.SyncedClusters = []string{"business", "deleted", "reachable", "unreachable"}
+	c := newTestController(hr)
+	// no creator is recorded
+	c.allowUnchecked = true
+	c.ClusterCache = commoncache.New(time.Minute, time.Minute)
+	c.ClusterCache.SetDefault("reachable", &cluster.Info{Name: "reachable", Endpoint: apiserver.URL})
+	c.ClusterCache.SetDefault("unreachable", &cluster.Info{Name: "unreachable", Endpoint: closed.URL})
+
+	// the deleted cluster is pruned, the unreachable one is kept to be retried later
+	lingering := c.removeUntargetedClusters(hr, []string{"business"})
+	assert.Equal(t, []string{"unreachable"}, lingering)
+
+	var events []string
+	for len(c.recorder.(*record.FakeRecorder).Events) > 0 {
+		events = append(events, <-c.recorder.(*record.FakeRecorder).Events)
+	}
+	assert.Len(t, 2, events)
+	assert.True(t, strings.HasPrefix(events[0], "Normal "+ClusterPruned))
+	assert.True(t, strings.Contains(events[0], "deleted"))
+	assert.True(t, strings.HasPrefix(events[1], "Normal "+UninstalledFromCluster))
+	assert.True(t, strings.Contains(events[1], "reachable"))
+}
@@ -69,12 +69,17 @@ func (c *Controller) initWatchForCluster(stopCh <-chan struct{}, cluster *cluste
 
 	informerFactory := informers.NewSharedInformerFactory(client, defaultResyncDuration)
 	informer := informerFactory.App().V1alpha1().HelmRequests()
+	if err := informer.Informer().AddIndexers(cache.Indexers{dependencyIndex: dependencyIndexFunc}); err != nil {
+		klog.Warningf("add indexers for cluster %s error: %s", cluster.Name, err.Error())
+		return err
+	}
 
 	c.clusterHelmRequestListers[cluster.Name] = informer.Lister()
 	c.clusterHelmRequestSynced[cluster.Name] = informer.Informer().HasSynced
+	c.clusterHelmRequestIndexers[cluster.Name] = informer.Informer().GetIndexer()
 	c.clusterWorkQueues[cluster.Name] = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), cluster.Name)
 	c.clusterClients[cluster.Name] = client
-	c.clusterRecorders[cluster.Name] = c.createEventRecorder(cluster.Name, coreClient)
+	c.clusterRecorders[cluster.Name] = newEventRecorder(cluster.Name, coreClient)
 
 	// add event handler
 	informer.Informer().AddEventHandler(c.newClusterHelmRequestHandler(cluster.Name))
@@ -88,6 +93,7 @@ func (c *Controller) initWatchForCluster(stopCh <-chan struct{}, cluster *cluste
 func (c *Controller) CleanupClusterWatch(name string) {
 	c.clusterHelmRequestListers[name] = nil
 	c.clusterHelmRequestSynced[name] = nil
+	c.clusterHelmRequestIndexers[name] = nil
 	c.clusterWorkQueues[name] = nil
 	c.clusterClients[name] = nil
 	c.clusterRecorders[name] = nil
@@ -115,10 +121,10 @@ func (c *Controller) restartClusterWatch(cluster *cluster.Info) error {
 
 }
 
-// createEventRecorder create event recoder for a cluster
+// newEventRecorder create event recoder for a cluster
 // create the recoder manually is easier to user the method provides by controller-runtime.Manager. Maybe?
 // TODO: change all args of cluster to cluster (from `name`)
-func (c *Controller) createEventRecorder(cluster string, client kubernetes.Interface) record.EventRecorder {
+func newEventRecorder(cluster string, client kubernetes.Interface) record.EventRecorder {
 	klog.Info("Creating event broadcaster for cluster: ", cluster)
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(klog.Infof)
@@ -298,6 +304,7 @@ func (c *Controller) deleteClusterHelmRequestHandler(obj interface{}, name strin
 		}
 	}
 	klog.Infof("receive delete event, cluster %s, : %+v", name, hr)
+	c.enqueueDependencies(hr, name)
 
 	hr = hr.DeepCopy()
 	hr.ClusterName = name
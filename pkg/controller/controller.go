@@ -19,8 +19,10 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/alauda/captain/pkg/cluster"
 	clusterclientset "github.com/alauda/captain/pkg/clusterregistry/client/clientset/versioned"
 	"github.com/alauda/captain/pkg/config"
 	"github.com/alauda/captain/pkg/helm"
@@ -53,6 +55,9 @@ type clusterConfig struct {
 	// clusterNamespace is the namespace that all the Cluster resource lives in
 	clusterNamespace string
 
+	// sources are where we discover clusters from, see --cluster-sources
+	sources []cluster.Source
+
 	globalClusterName string
 }
 
@@ -70,12 +75,16 @@ type Controller struct {
 	// this is where all the ChartRepo/Charts lives
 	systemNamespace string
 
+	// allowUnchecked allows the HelmRequests without a recorded creator to deploy without permission checks
+	allowUnchecked bool
+
 	// restConfig is the kubernetes rest config for the current cluster, used for
 	// sync HelmRequest who's cluster name is "".
 	restConfig *rest.Config
 
-	helmRequestLister listers.HelmRequestLister
-	helmRequestSynced cache.InformerSynced
+	helmRequestLister  listers.HelmRequestLister
+	helmRequestSynced  cache.InformerSynced
+	helmRequestIndexer cache.Indexer
 
 	// ClusterCache is used to store Cluster resource
 	ClusterCache *commoncache.Cache
@@ -93,11 +102,16 @@ type Controller struct {
 
 	// To support multiple cluster, we have to watch all the clusters for HelmRequests
 	// May be we should remove the old field for global cluster...
-	clusterHelmRequestListers map[string]listers.HelmRequestLister
-	clusterHelmRequestSynced  map[string]cache.InformerSynced
-	clusterWorkQueues         map[string]workqueue.RateLimitingInterface
-	clusterClients            map[string]clientset.Interface
-	clusterRecorders          map[string]record.EventRecorder
+	clusterHelmRequestListers  map[string]listers.HelmRequestLister
+	clusterHelmRequestSynced   map[string]cache.InformerSynced
+	clusterHelmRequestIndexers map[string]cache.Indexer
+	clusterWorkQueues          map[string]workqueue.RateLimitingInterface
+	clusterClients             map[string]clientset.Interface
+	clusterRecorders           map[string]record.EventRecorder
+
+	// retries are the failed attempts of the helmrequests, by the work queue keys
+	retries     map[string]*retryState
+	retriesLock sync.Mutex
 
 	stopCh <-chan struct{}
 }
@@ -120,12 +134,20 @@ func NewController(mgr manager.Manager, opt *config.Options, ctx context.Context
 		return nil, err
 	}
 
+	sources, err := cluster.NewSources(cfg, opt.GetClusterSources(), opt.ClusterNamespace)
+	if err != nil {
+		return nil, err
+	}
+
 	// kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
 	appInformerFactory := informers.NewSharedInformerFactory(appClient, time.Second*30)
 	// chartRepoInformerFactory := informers.NewSharedInformerFactoryWithOptions(appClient, time.Second*30, informers.WithNamespace(opt.ChartRepoNamespace))
 
 	informer := appInformerFactory.App().V1alpha1().HelmRequests()
 	// repoInformer := chartRepoInformerFactory.App().V1alpha1().ChartRepos()
+	if err := informer.Informer().AddIndexers(cache.Indexers{dependencyIndex: dependencyIndexFunc}); err != nil {
+		return nil, err
+	}
 
 	controller := &Controller{
 		kubeClient:   kubeClient,
@@ -133,9 +155,11 @@ func NewController(mgr manager.Manager, opt *config.Options, ctx context.Context
 		clusterConfig: clusterConfig{
 			clusterNamespace:  opt.ClusterNamespace,
 			clusterClient:     clusterClient,
+			sources:           sources,
 			globalClusterName: opt.GlobalClusterName,
 		},
 		systemNamespace:   opt.ChartRepoNamespace,
+		allowUnchecked:    opt.AllowUncheckedHelmRequests,
 		restConfig:        cfg,
 		recorder:          mgr.GetEventRecorderFor(util.ComponentName),
 		helmRequestLister: informer.Lister(),
@@ -147,12 +171,17 @@ func NewController(mgr manager.Manager, opt *config.Options, ctx context.Context
 		// refresh frequently
 		ClusterCache: commoncache.New(1*time.Minute, 5*time.Minute),
 
+		// find the dependents of a helmrequest by the index
+		helmRequestIndexer: informer.Informer().GetIndexer(),
+
 		// only init data structures, start it later
-		clusterHelmRequestListers: make(map[string]listers.HelmRequestLister),
-		clusterHelmRequestSynced:  make(map[string]cache.InformerSynced),
-		clusterWorkQueues:         make(map[string]workqueue.RateLimitingInterface),
-		clusterClients:            make(map[string]clientset.Interface),
-		clusterRecorders:          make(map[string]record.EventRecorder),
+		clusterHelmRequestListers:  make(map[string]listers.HelmRequestLister),
+		clusterHelmRequestSynced:   make(map[string]cache.InformerSynced),
+		clusterHelmRequestIndexers: make(map[string]cache.Indexer),
+		clusterWorkQueues:          make(map[string]workqueue.RateLimitingInterface),
+		clusterClients:             make(map[string]clientset.Interface),
+		clusterRecorders:           make(map[string]record.EventRecorder),
+		retries:                    make(map[string]*retryState),
 
 		stopCh: ctx.Done(),
 	}
@@ -318,6 +347,7 @@ func (c *Controller) setPartialSyncedStatus(helmRequest *appv1.HelmRequest) erro
 	request.Status.LastSpecHash = h
 	request.Status.Reason = ""
 	request.Status.Phase = appv1.HelmRequestPartialSynced
+	request.Status.SyncedClusters = helmRequest.Status.SyncedClusters
 	return helm.UpdateHelmRequestStatus(client, request)
 }
 
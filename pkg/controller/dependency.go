@@ -1,70 +1,534 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/alauda/captain/pkg/dependency"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
 	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	appv1alpha1 "github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
 
-// getHelmRequestDependencies get dependencies for a HelmRequest resource
-// If the target HelmRequest has no dependencies, return nil. Otherwise get the dependencies and return
-func (c *Controller) getHelmRequestDependencies(hr *appv1.HelmRequest) ([]*appv1.HelmRequest, error) {
-	var data []*appv1.HelmRequest
-	deps := hr.Spec.Dependencies
-	if len(deps) == 0 {
+const (
+	// ConditionDependencies reports the state of each dependency of a helmrequest
+	ConditionDependencies appv1.HelmRequestConditionType = "Dependencies"
+	// ConditionDependents reports the helmrequests blocking the deletion, since they depend on it
+	ConditionDependents appv1.HelmRequestConditionType = "Dependents"
+
+	// readinessRecheckInterval is how often to check a dependency requires Ready/Healthy, resources becoming
+	// ready do not change the dependency itself
+	readinessRecheckInterval = 10 * time.Second
+	// dependencyRecheckInterval is the fallback recheck interval of a dependency requires Synced, it's enqueued
+	// when the dependency's status changes
+	dependencyRecheckInterval = time.Minute
+)
+
+// DependencyError means some of the dependencies are not satisfied yet
+type DependencyError struct {
+	// Blocking are the messages of the unsatisfied dependencies
+	Blocking []string
+	// recheck is when to check the dependencies again
+	recheck time.Duration
+}
+
+func (e *DependencyError) Error() string {
+	return "waiting for dependencies: " + strings.Join(e.Blocking, "; ")
+}
+
+// getHelmRequestDependencies get dependencies for a HelmRequest resource, they live in the same cluster as
+// the HelmRequest unless another cluster is specified. If the target HelmRequest has no dependencies, return
+// nil. A dependency not found is nil, an implicit one not found is dropped, the ConfigMap/Secret it refers to
+// is not an outputs.
+func (c *Controller) getHelmRequestDependencies(hr *appv1.HelmRequest) ([]dependency.Dependency, []*appv1.HelmRequest, error) {
+	parsed, err := dependency.Parse(hr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(parsed) == 0 {
 		klog.V(4).Infof("HelmRequest %s has no dependencies", hr.GetName())
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	cluster := c.getDeployCluster(hr)
-	for _, name := range deps {
-		d, err := c.getClusterAppClient(cluster).AppV1().HelmRequests(hr.GetNamespace()).Get(name, metav1.GetOptions{})
+	var deps []dependency.Dependency
+	var data []*appv1.HelmRequest
+	for _, dep := range parsed {
+		cluster := c.dependencyCluster(hr.ClusterName, dep)
+		client := c.getClusterAppClient(cluster)
+		if client == nil {
+			deps = append(deps, dep)
+			data = append(data, nil)
+			continue
+		}
+		d, err := client.AppV1().HelmRequests(dep.Namespace).Get(dep.Name, metav1.GetOptions{})
 		if err != nil {
-			klog.Errorf("Retrieve dependency %s for %s error: %s", name, hr.GetName(), err.Error())
-			return nil, err
+			if errors.IsNotFound(err) {
+				if !dep.Implicit {
+					deps = append(deps, dep)
+					data = append(data, nil)
+				}
+				continue
+			}
+			klog.Errorf("Retrieve dependency %s for %s error: %s", dep.Key(), hr.GetName(), err.Error())
+			return nil, nil, err
 		}
+		d.ClusterName = cluster
+		deps = append(deps, dep)
 		data = append(data, d)
 	}
 
-	return data, nil
+	return deps, data, nil
 
 }
 
+// dependencyCluster returns the cluster the dependency lives in, cluster is where the dependent lives in,
+// empty means the global cluster
+func (c *Controller) dependencyCluster(cluster string, dep dependency.Dependency) string {
+	switch dep.Cluster {
+	case "":
+		return cluster
+	case c.clusterConfig.globalClusterName:
+		return ""
+	default:
+		return dep.Cluster
+	}
+}
+
 // checkDependenciesForHelmRequest checks if the dependencies for the target HelmRequest has been
-// satisfied. This is not and easy job, since we support installToAllClusters, and the clusters live and
-// go all the time. For more details please check: http://confluence.alaudatech.com/pages/viewpage.action?pageId=48729300
-// If the check not pass or somethings goes wrong, return an error contains the detailed reson, this is
-// better than a bool var.
+// satisfied in every target cluster, and records the state of each dependency in the Dependencies condition.
+// A dependency in another cluster is checked in it's own target clusters instead. If the check not pass,
+// returns a DependencyError contains the blocking dependencies.
 func (c *Controller) checkDependenciesForHelmRequest(hr *appv1.HelmRequest) error {
-	deps, err := c.getHelmRequestDependencies(hr)
-	if err != nil {
+	deps, data, err := c.getHelmRequestDependencies(hr)
+	if err != nil || len(deps) == 0 {
 		return err
 	}
 
-	if !hr.Spec.InstallToAllClusters {
-		cluster := c.getDeployCluster(hr)
-		for _, dep := range deps {
-			if !dep.IsClusterSynced(cluster) && (cluster == "" && !dep.IsClusterSynced(c.clusterConfig.globalClusterName)) {
-				return fmt.Errorf("dependency %s of %s is not synced to cluster %s yet", dep.Name, hr.Name, cluster)
+	var clusters []string
+	if hr.Spec.InstallToAllClusters {
+		all, err := c.getAllClusters()
+		if err != nil {
+			return fmt.Errorf("get clusters info error when check dependencies for %s : %s", hr.Name, err.Error())
+		}
+		clusters = clusterNames(all)
+	} else {
+		clusters = []string{c.currentTarget(hr).Cluster}
+	}
+
+	result := &DependencyError{recheck: dependencyRecheckInterval}
+	var crossCluster []string
+	for i, dep := range deps {
+		var msg string
+		if c.dependencyCluster(hr.ClusterName, dep) != hr.ClusterName {
+			msg = c.checkCrossClusterDependency(dep, data[i])
+		} else {
+			msg = c.checkDependency(dep, data[i], clusters)
+		}
+		if msg == "" {
+			continue
+		}
+		result.Blocking = append(result.Blocking, fmt.Sprintf("%s: %s", dep, msg))
+		if dep.Requirement != dependency.RequireSynced {
+			result.recheck = readinessRecheckInterval
+		}
+		if dep.Cluster != "" {
+			crossCluster = append(crossCluster, dep.String())
+		}
+	}
+
+	if len(result.Blocking) > 0 {
+		// cycles across clusters can not be rejected by the webhook, which only sees the local cluster
+		if len(crossCluster) > 0 {
+			if cycle := c.findCrossClusterCycle(hr); cycle != nil {
+				msg := "dependencies form a cycle across clusters: " + strings.Join(cycle, " -> ")
+				if c.recordCondition(hr, newCondition(ConditionDependencies, "Cycle", msg, corev1.ConditionFalse)) {
+					c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "DependencyCycle", msg)
+				}
+				return &DependencyError{Blocking: []string{msg}, recheck: dependencyRecheckInterval}
+			}
+		}
+		changed := c.recordCondition(hr, newCondition(ConditionDependencies, "Waiting", result.Error(), corev1.ConditionFalse))
+		// the dependents in other clusters are only enqueued by the fallback recheck, make it visible
+		if changed && len(crossCluster) > 0 {
+			c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "CrossClusterDependencyBlocking",
+				"Waiting for dependencies in other clusters: "+strings.Join(crossCluster, ", "))
+		}
+		return result
+	}
+	var names []string
+	for _, dep := range deps {
+		names = append(names, dep.String())
+	}
+	c.recordCondition(hr, newCondition(ConditionDependencies, "Satisfied",
+		"All dependencies are satisfied: "+strings.Join(names, ", "), corev1.ConditionTrue))
+	return nil
+}
+
+// findCrossClusterCycle walks the dependencies of hr through the informers of all the watched clusters, returns a
+// cycle like [a b a] with the keys prefixed by the clusters, or nil if there is none
+func (c *Controller) findCrossClusterCycle(hr *appv1.HelmRequest) []string {
+	clusterName := func(cluster string) string {
+		if cluster == "" {
+			return c.clusterConfig.globalClusterName
+		}
+		return cluster
+	}
+
+	graph := dependency.NewGraph(nil)
+	start := dependency.ClusterKey(clusterName(hr.ClusterName), hr.Namespace, hr.Name)
+	visited := map[string]bool{}
+	queue := []*appv1.HelmRequest{hr}
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		key := dependency.ClusterKey(clusterName(item.ClusterName), item.Namespace, item.Name)
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+
+		deps, err := dependency.Parse(item)
+		if err != nil {
+			continue
+		}
+		for i := range deps {
+			cluster := c.dependencyCluster(item.ClusterName, deps[i])
+			deps[i].Cluster = clusterName(cluster)
+			if next := c.getCachedHelmRequest(cluster, deps[i].Namespace, deps[i].Name); next != nil {
+				queue = append(queue, next)
 			}
 		}
+		graph.Set(key, deps)
+	}
+	return graph.FindCycle(start)
+}
+
+// getCachedHelmRequest gets the helmrequest from the informer of the cluster, nil if it's not found or the
+// cluster is not watched. It's ClusterName is set to the cluster.
+func (c *Controller) getCachedHelmRequest(cluster, namespace, name string) *appv1.HelmRequest {
+	indexer := c.getHelmRequestIndexer(cluster)
+	if indexer == nil {
+		return nil
+	}
+	obj, exists, err := indexer.GetByKey(dependency.Key(namespace, name))
+	if err != nil || !exists {
 		return nil
 	}
+	alpha, ok := obj.(*appv1alpha1.HelmRequest)
+	if !ok {
+		return nil
+	}
+	hr, err := convertToV1(alpha.DeepCopy())
+	if err != nil {
+		return nil
+	}
+	hr.ClusterName = cluster
+	return hr
+}
+
+// checkDependency checks one dependency in all the clusters, returns why it's not satisfied, or empty if it is
+func (c *Controller) checkDependency(dep dependency.Dependency, hr *appv1.HelmRequest, clusters []string) string {
+	if hr == nil {
+		return "not found"
+	}
+
+	for _, cluster := range clusters {
+		if !c.isDependencySynced(hr, cluster) {
+			return fmt.Sprintf("not synced to cluster %s yet", cluster)
+		}
+		if dep.Requirement == dependency.RequireSynced {
+			continue
+		}
+		if dep.Requirement == dependency.RequireHealthy && !helm.IsHelmRequestSynced(hr) {
+			return "has pending updates"
+		}
+
+		name := cluster
+		if name == c.clusterConfig.globalClusterName {
+			name = ""
+		}
+		info, err := c.getClusterInfo(name)
+		if err != nil {
+			return fmt.Sprintf("get cluster %s error: %s", cluster, err.Error())
+		}
+		notReady, err := helm.NotReadyResources(context.Background(), info, hr, dep.Requirement == dependency.RequireHealthy)
+		if err != nil {
+			return fmt.Sprintf("check readiness in cluster %s error: %s", cluster, err.Error())
+		}
+		if len(notReady) > 0 {
+			return fmt.Sprintf("%d resources not ready in cluster %s: %s", len(notReady), cluster, strings.Join(notReady, ", "))
+		}
+	}
+	return ""
+}
+
+// checkCrossClusterDependency checks a dependency lives in another cluster, in the clusters it targets, returns
+// why it's not satisfied, or empty if it is
+func (c *Controller) checkCrossClusterDependency(dep dependency.Dependency, hr *appv1.HelmRequest) string {
+	if hr == nil {
+		if c.getClusterAppClient(c.dependencyCluster("", dep)) == nil {
+			return fmt.Sprintf("cluster %s is not watched", dep.Cluster)
+		}
+		return "not found"
+	}
+	if hr.Status.Phase != appv1.HelmRequestSynced {
+		return "not synced yet"
+	}
+
+	clusters := []string{c.currentTarget(hr).Cluster}
+	if hr.Spec.InstallToAllClusters {
+		clusters = hr.Status.SyncedClusters
+	}
+	return c.checkDependency(dep, hr, clusters)
+}
+
+// isDependencySynced checks if the dependency is synced to the cluster
+func (c *Controller) isDependencySynced(hr *appv1.HelmRequest, cluster string) bool {
+	if hr.Spec.InstallToAllClusters {
+		return hr.IsClusterSynced(cluster)
+	}
+	return hr.Status.Phase == appv1.HelmRequestSynced && c.currentTarget(hr).Cluster == cluster
+}
+
+func newCondition(ty appv1.HelmRequestConditionType, reason, message string, status corev1.ConditionStatus) *appv1.HelmRequestCondition {
+	now := metav1.Now()
+	return &appv1.HelmRequestCondition{
+		Type:               ty,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: &now,
+	}
+}
 
-	clusters, err := c.getAllClusters()
+// recordCondition updates the condition if it's changed, errors are only logged. Returns whether the condition
+// is changed.
+func (c *Controller) recordCondition(hr *appv1.HelmRequest, cond *appv1.HelmRequestCondition) bool {
+	for _, item := range hr.Status.Conditions {
+		if item.Type == cond.Type && item.Reason == cond.Reason && item.Message == cond.Message {
+			return false
+		}
+	}
+	if err := helm.AddConditionForHelmRequest(cond, hr, c.getAppClient(hr)); err != nil {
+		klog.Errorf("update %s condition of %s error: %s", cond.Type, hr.Name, err.Error())
+	}
+	return true
+}
+
+// isConditionTrue checks if the helmrequest has the condition with status True
+func isConditionTrue(hr *appv1.HelmRequest, ty appv1.HelmRequestConditionType) bool {
+	for _, item := range hr.Status.Conditions {
+		if item.Type == ty {
+			return item.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// dependencyIndex indexes the helmrequests in the informers by the keys of their dependencies, so the
+// dependents of a helmrequest are found without listing and parsing all the helmrequests
+const dependencyIndex = "dependencies"
+
+// dependencyIndexFunc returns the keys of the dependencies of a helmrequest, the same as the keys in the
+// dependency graph. HelmRequests with invalid dependencies are not indexed.
+func dependencyIndexFunc(obj interface{}) ([]string, error) {
+	alpha, ok := obj.(*appv1alpha1.HelmRequest)
+	if !ok {
+		return nil, nil
+	}
+	hr, err := convertToV1(alpha.DeepCopy())
+	if err != nil {
+		return nil, err
+	}
+	deps, err := dependency.Parse(hr)
 	if err != nil {
-		return fmt.Errorf("get clusters info error when check dependencies for %s : %s", hr.Name, err.Error())
+		return nil, nil
+	}
+	var keys []string
+	for _, dep := range deps {
+		keys = append(keys, dep.Key())
+	}
+	return keys, nil
+}
+
+// getHelmRequestIndexer returns the informer indexer of the cluster, empty name means the global cluster
+func (c *Controller) getHelmRequestIndexer(name string) cache.Indexer {
+	if name == "" {
+		return c.helmRequestIndexer
 	}
+	return c.clusterHelmRequestIndexers[name]
+}
 
-	for _, item := range clusters {
-		for _, dep := range deps {
-			if !dep.IsClusterSynced(item.Name) {
-				return fmt.Errorf("dependency %s of %s is not synced to cluster %s yet", dep.Name, hr.Name, item.Name)
+// getDependents returns the helmrequests depend on hr directly, hr lives in the cluster. The dependents in all
+// the watched clusters are returned, their ClusterName is set to where they live.
+func (c *Controller) getDependents(hr *appv1.HelmRequest, cluster string) ([]*appv1.HelmRequest, error) {
+	name := cluster
+	if name == "" {
+		name = c.clusterConfig.globalClusterName
+	}
+	remoteKey := dependency.ClusterKey(name, hr.Namespace, hr.Name)
+
+	var result []*appv1.HelmRequest
+	for _, from := range c.watchedClusters() {
+		indexer := c.getHelmRequestIndexer(from)
+		if indexer == nil {
+			return nil, fmt.Errorf("cluster %s is not watched yet", from)
+		}
+		keys := []string{remoteKey}
+		if from == cluster {
+			keys = append(keys, dependency.Key(hr.Namespace, hr.Name))
+		}
+
+		found := make(map[string]bool)
+		for _, key := range keys {
+			items, err := indexer.ByIndex(dependencyIndex, key)
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range items {
+				alpha, ok := item.(*appv1alpha1.HelmRequest)
+				if !ok || found[dependency.Key(alpha.Namespace, alpha.Name)] {
+					continue
+				}
+				found[dependency.Key(alpha.Namespace, alpha.Name)] = true
+				converted, err := convertToV1(alpha.DeepCopy())
+				if err != nil {
+					klog.Errorf("can not convert object to v1 helmrequest : %+v", item)
+					continue
+				}
+				converted.ClusterName = from
+				result = append(result, converted)
 			}
 		}
 	}
-	return nil
+	return result, nil
+}
+
+// watchedClusters returns the clusters whose helmrequests are watched, empty means the global cluster
+func (c *Controller) watchedClusters() []string {
+	clusters := []string{""}
+	for name := range c.clusterHelmRequestListers {
+		clusters = append(clusters, name)
+	}
+	return clusters
+}
+
+// dependentKey returns the key of the dependent hr to report, prefixed with the cluster if it's not the cluster
+func (c *Controller) dependentKey(hr *appv1.HelmRequest, cluster string) string {
+	if hr.ClusterName == cluster {
+		return dependency.Key(hr.Namespace, hr.Name)
+	}
+	name := hr.ClusterName
+	if name == "" {
+		name = c.clusterConfig.globalClusterName
+	}
+	return dependency.ClusterKey(name, hr.Namespace, hr.Name)
+}
+
+// enqueueDependents enqueues the helmrequests depend on hr immediately, hr lives in the cluster
+func (c *Controller) enqueueDependents(hr *appv1.HelmRequest, cluster string) {
+	dependents, err := c.getDependents(hr, cluster)
+	if err != nil {
+		klog.Errorf("get dependents of %s/%s error: %s", hr.Namespace, hr.Name, err.Error())
+		return
+	}
+	for _, item := range dependents {
+		klog.Infof("dependency %s/%s changed, enqueue %s", hr.Namespace, hr.Name, c.dependentKey(item, cluster))
+		c.enqueueKey(item.ClusterName, dependency.Key(item.Namespace, item.Name), 0)
+	}
+}
+
+// enqueueDependencies enqueues the dependencies of hr immediately, they may be waiting for hr to be deleted
+func (c *Controller) enqueueDependencies(hr *appv1.HelmRequest, cluster string) {
+	deps, err := dependency.Parse(hr)
+	if err != nil {
+		return
+	}
+	for _, dep := range deps {
+		klog.Infof("dependent %s/%s deleted, enqueue %s", hr.Namespace, hr.Name, dep.Key())
+		c.enqueueKey(c.dependencyCluster(cluster, dep), dependency.Key(dep.Namespace, dep.Name), 0)
+	}
+}
+
+// DependentsError means a helmrequest can not be deleted, since other helmrequests still depend on it
+type DependentsError struct {
+	Dependents []string
+}
+
+func (e *DependentsError) Error() string {
+	return "waiting for dependents to be deleted: " + strings.Join(e.Dependents, ", ")
+}
+
+// waitForDependents is called before deleting hr, the release is kept until all the helmrequests depend on
+// it are gone. If cascade delete is enabled, the dependents are deleted first. Only the dependents hr's users
+// are allowed to delete are waited for, so a tenant can not block or cascade delete another tenant's helmrequests.
+func (c *Controller) waitForDependents(hr *appv1.HelmRequest) error {
+	dependents, err := c.getDependents(hr, hr.ClusterName)
+	if err != nil || len(dependents) == 0 {
+		return err
+	}
+
+	cascade := isSwitchEnabled(hr, util.CascadeDeleteAnnotation)
+	result := &DependentsError{}
+	for _, item := range dependents {
+		allowed, err := c.isDependentOwned(hr, item)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			klog.Infof("dependent %s of %s is not deletable by it's users, ignore it", c.dependentKey(item, hr.ClusterName), hr.Name)
+			continue
+		}
+		result.Dependents = append(result.Dependents, c.dependentKey(item, hr.ClusterName))
+		if !cascade || item.DeletionTimestamp != nil {
+			continue
+		}
+		klog.Infof("cascade delete dependent %s of %s", c.dependentKey(item, hr.ClusterName), hr.Name)
+		err = c.getAppClient(item).AppV1().HelmRequests(item.Namespace).Delete(item.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if len(result.Dependents) == 0 {
+		return nil
+	}
 
+	reason := "WaitingForDependents"
+	if cascade {
+		reason = "DeletingDependents"
+	}
+	c.recordCondition(hr, newCondition(ConditionDependents, reason, result.Error(), corev1.ConditionFalse))
+	return result
+}
+
+// isDependentOwned checks if the users of hr are allowed to delete the dependent. The ones in the same cluster
+// and namespace are, the ones in other namespaces of the global cluster are checked by SubjectAccessReview, the
+// ones in other clusters are not, since the users are only known in the global cluster.
+func (c *Controller) isDependentOwned(hr, dependent *appv1.HelmRequest) (bool, error) {
+	if dependent.ClusterName == hr.ClusterName && dependent.Namespace == hr.Namespace {
+		return true, nil
+	}
+	if dependent.ClusterName != "" || hr.ClusterName != "" {
+		return false, nil
+	}
+	return helm.IsHelmRequestAccessAllowed(c.kubeClient, hr, "delete", dependent.Namespace, dependent.Name)
+}
+
+// enqueueKey puts the key of a helmrequest to the work queue of the cluster it lives in after the duration
+func (c *Controller) enqueueKey(cluster, key string, after time.Duration) {
+	if cluster == "" {
+		c.workQueue.AddAfter(key, after)
+		return
+	}
+	if queue, ok := c.clusterWorkQueues[cluster]; ok {
+		queue.AddAfter(clusterKey(key, cluster), after)
+	}
 }
@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	appv1alpha1 "github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	"github.com/thoas/go-funk"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetDependents(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{dependencyIndex: dependencyIndexFunc})
+	for _, hr := range []*appv1alpha1.HelmRequest{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       appv1alpha1.HelmRequestSpec{Dependencies: []string{"db"}},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend", Annotations: map[string]string{
+			util.DependenciesAnnotation: "default/app:Ready,global/default/db",
+		}}},
+	} {
+		assert.Nil(t, indexer.Add(hr))
+	}
+	c := &Controller{
+		helmRequestIndexer: indexer,
+		clusterConfig:      clusterConfig{globalClusterName: "global"},
+	}
+
+	db, err := convertToV1(&appv1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}})
+	assert.Nil(t, err)
+	dependents, err := c.getDependents(db, "")
+	assert.Nil(t, err)
+	var names []string
+	for _, item := range dependents {
+		names = append(names, item.Namespace+"/"+item.Name)
+	}
+	assert.Len(t, 2, names)
+	assert.True(t, names[0] == "web/frontend" || names[1] == "web/frontend")
+
+	app, err := convertToV1(&appv1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}})
+	assert.Nil(t, err)
+	dependents, err = c.getDependents(app, "")
+	assert.Nil(t, err)
+	assert.Len(t, 1, dependents)
+	assert.Equal(t, "frontend", dependents[0].Name)
+}
+
+func TestFindCrossClusterCycle(t *testing.T) {
+	newIndexer := func(hrs ...*appv1alpha1.HelmRequest) cache.Indexer {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{dependencyIndex: dependencyIndexFunc})
+		for _, hr := range hrs {
+			assert.Nil(t, indexer.Add(hr))
+		}
+		return indexer
+	}
+	newDependent := func(name, deps string) *appv1alpha1.HelmRequest {
+		return &appv1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Annotations: map[string]string{util.DependenciesAnnotation: deps},
+		}}
+	}
+	c := &Controller{
+		helmRequestIndexer: newIndexer(newDependent("a", "business/default/b")),
+		clusterHelmRequestIndexers: map[string]cache.Indexer{
+			"business": newIndexer(newDependent("b", "c"), newDependent("c", "global/default/a")),
+		},
+		clusterConfig: clusterConfig{globalClusterName: "global"},
+	}
+
+	a, err := convertToV1(newDependent("a", "business/default/b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"global/default/a", "business/default/b", "business/default/c", "global/default/a"},
+		c.findCrossClusterCycle(a))
+
+	// no cycle after c is changed
+	c.clusterHelmRequestIndexers["business"] = newIndexer(newDependent("b", "c"), newDependent("c", ""))
+	assert.Nil(t, c.findCrossClusterCycle(a))
+}
+
+func TestWaitForDependents(t *testing.T) {
+	newAlpha := func(namespace, name string, annotations map[string]string) *appv1alpha1.HelmRequest {
+		return &appv1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: annotations,
+		}}
+	}
+	// db is created by alice, app in the same namespace and frontend of bob in another namespace depend on it
+	alphas := []*appv1alpha1.HelmRequest{
+		newAlpha("default", "db", map[string]string{util.CreatorAnnotation: "alice"}),
+		newAlpha("default", "app", map[string]string{util.DependenciesAnnotation: "db"}),
+		newAlpha("web", "frontend", map[string]string{
+			util.CreatorAnnotation:      "bob",
+			util.DependenciesAnnotation: "default/db",
+		}),
+	}
+	newController := func(aliceNamespaces ...string) *Controller {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{dependencyIndex: dependencyIndexFunc})
+		var objects []runtime.Object
+		for _, alpha := range alphas {
+			assert.Nil(t, indexer.Add(alpha))
+			hr, err := convertToV1(alpha.DeepCopy())
+			assert.Nil(t, err)
+			objects = append(objects, hr)
+		}
+		kubeClient := kubefake.NewSimpleClientset()
+		kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			sar.Status.Allowed = sar.Spec.User == "alice" && funk.ContainsString(aliceNamespaces, sar.Spec.ResourceAttributes.Namespace)
+			return true, sar, nil
+		})
+
+		c := newTestController(objects...)
+		c.kubeClient = kubeClient
+		c.helmRequestIndexer = indexer
+		c.clusterConfig = clusterConfig{globalClusterName: "global"}
+		return c
+	}
+	getDB := func(c *Controller) *appv1.HelmRequest {
+		hr, err := c.appClientSet.AppV1().HelmRequests("default").Get("db", metav1.GetOptions{})
+		assert.Nil(t, err)
+		return hr
+	}
+	reasonOf := func(hr *appv1.HelmRequest) string {
+		for _, cond := range hr.Status.Conditions {
+			if cond.Type == ConditionDependents {
+				return cond.Reason
+			}
+		}
+		return ""
+	}
+	exists := func(c *Controller, namespace, name string) bool {
+		_, err := c.appClientSet.AppV1().HelmRequests(namespace).Get(name, metav1.GetOptions{})
+		return err == nil
+	}
+
+	// frontend belongs to another tenant, it does not block the deletion
+	c := newController()
+	err := c.waitForDependents(getDB(c))
+	dependents, ok := err.(*DependentsError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"default/app"}, dependents.Dependents)
+	assert.Equal(t, "WaitingForDependents", reasonOf(getDB(c)))
+	assert.True(t, exists(c, "default", "app"))
+
+	// alice is allowed to delete helmrequests in web, so she waits for frontend as well
+	c = newController("default", "web")
+	err = c.waitForDependents(getDB(c))
+	dependents, ok = err.(*DependentsError)
+	assert.True(t, ok)
+	assert.Len(t, 2, dependents.Dependents)
+
+	// cascade delete only deletes the dependents alice is allowed to delete
+	alphas[0].Annotations[util.CascadeDeleteAnnotation] = "true"
+	c = newController()
+	err = c.waitForDependents(getDB(c))
+	dependents, ok = err.(*DependentsError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"default/app"}, dependents.Dependents)
+	assert.Equal(t, "DeletingDependents", reasonOf(getDB(c)))
+	assert.False(t, exists(c, "default", "app"))
+	assert.True(t, exists(c, "web", "frontend"))
+
+	c = newController("default", "web")
+	assert.NotNil(t, c.waitForDependents(getDB(c)))
+	assert.False(t, exists(c, "web", "frontend"))
+
+	// the deletion waits in the work queue, the dependents are rechecked later
+	alphas[0].DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	c = newController()
+	queue := &delayRecorder{RateLimitingInterface: c.workQueue}
+	c.workQueue = queue
+	assert.Nil(t, c.syncHandler("default/db"))
+	assert.Equal(t, []time.Duration{dependencyRecheckInterval}, queue.delays)
+
+	// nothing to wait once the dependents in the namespace are gone
+	alphas = alphas[:1]
+	c = newController()
+	assert.Nil(t, c.waitForDependents(getDB(c)))
+}
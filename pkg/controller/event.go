@@ -20,6 +20,21 @@ const (
 	// FailedDelete means failed to delete a resource
 	FailedDelete = "FailedDelete"
 
+	// SuccessfulImport means release records of helm cli are imported
+	SuccessfulImport = "Imported"
+
+	// SuccessfulExport means release records are exported to helm cli's storage
+	SuccessfulExport = "Exported"
+
+	// TargetChanged means the release is moved to a new cluster/namespace/name
+	TargetChanged = "TargetChanged"
+
+	// UninstalledFromCluster means the release is uninstalled from a cluster which is not a target any more
+	UninstalledFromCluster = "UninstalledFromCluster"
+
+	// ClusterPruned means a cluster no longer exists, and is removed from the synced clusters
+	ClusterPruned = "ClusterPruned"
+
 	// ErrResourceExists is used as part of the Event 'reason' when a HelmRequest fails
 	// to sync due to a Deployment of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
@@ -42,3 +57,15 @@ func (c *Controller) sendFailedDeleteEvent(hr *appv1.HelmRequest, err error) {
 func (c *Controller) sendFailedSyncEvent(hr *appv1.HelmRequest, err error) {
 	c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, FailedSync, err.Error())
 }
+
+// sendImportedEvent send a event when release records of helm cli are imported
+func (c *Controller) sendImportedEvent(hr *appv1.HelmRequest, count int) {
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, SuccessfulImport,
+		fmt.Sprintf("Imported %d release records created by helm cli", count))
+}
+
+// sendExportedEvent send a event when release records are exported to helm cli's storage
+func (c *Controller) sendExportedEvent(hr *appv1.HelmRequest, count int) {
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, SuccessfulExport,
+		fmt.Sprintf("Exported %d release records to helm cli's storage", count))
+}
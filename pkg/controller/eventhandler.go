@@ -34,6 +34,11 @@ func (c *Controller) newHelmRequestHandler() cache.ResourceEventHandler {
 				return
 			}
 		}
+		// dependents may be waiting for the status
+		if !reflect.DeepEqual(oldHR.Status, newHR.Status) {
+			c.enqueueDependents(newHR, "")
+		}
+
 		// this is a bit of tricky
 		// 1. old and new -> 1 cluster => check version and spec
 		// 2. old and new -> N cluster => no check
@@ -63,8 +68,23 @@ func (c *Controller) newHelmRequestHandler() cache.ResourceEventHandler {
 		}
 	}
 
+	addFunc := func(obj interface{}) {
+		c.enqueueHelmRequest(obj)
+		hr, ok := obj.(*appv1.HelmRequest)
+		if !ok {
+			var err error
+			hr, err = convertToV1(obj)
+			if err != nil {
+				klog.Errorf("can not convert object to v1 helmrequest : %+v", obj)
+				return
+			}
+		}
+		// dependents may be waiting for it to be created
+		c.enqueueDependents(hr, "")
+	}
+
 	funcs := cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.enqueueHelmRequest,
+		AddFunc:    addFunc,
 		UpdateFunc: updateFunc,
 		DeleteFunc: c.deleteHandler,
 	}
@@ -95,6 +115,11 @@ func (c *Controller) newClusterHelmRequestHandler(name string) cache.ResourceEve
 				return
 			}
 		}
+		// dependents may be waiting for the status
+		if !reflect.DeepEqual(oldHR.Status, newHR.Status) {
+			c.enqueueDependents(newHR, name)
+		}
+
 		// this is a bit of tricky
 		// 1. old and new -> 1 cluster => check version and spec
 		// 2. old and new -> N cluster => no check
@@ -129,6 +154,17 @@ func (c *Controller) newClusterHelmRequestHandler(name string) cache.ResourceEve
 	addFunc := func(obj interface{}) {
 		klog.Infof("receive hr create event: %+v", obj)
 		c.enqueueClusterHelmRequest(obj, name)
+		hr, ok := obj.(*appv1.HelmRequest)
+		if !ok {
+			var err error
+			hr, err = convertToV1(obj)
+			if err != nil {
+				klog.Errorf("can not convert object to v1 helmrequest : %+v", obj)
+				return
+			}
+		}
+		// dependents may be waiting for it to be created
+		c.enqueueDependents(hr, name)
 	}
 
 	deleteFunc := func(obj interface{}) {
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/release"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// exportNoSync is the value of ExportHelmReleaseAnnotation to stop managing the helmrequest after export
+const exportNoSync = "no-sync"
+
+// exportHelmRequest exports the release history of the helmrequest to helm cli's storage in every cluster
+// it's deployed to, then remove the export annotation, and add the no-sync annotation if required.
+func (c *Controller) exportHelmRequest(hr *appv1.HelmRequest) error {
+	clusters := []string{c.getDeployCluster(hr)}
+	if hr.Spec.InstallToAllClusters {
+		clusters = hr.Status.SyncedClusters
+	}
+
+	count := 0
+	for _, name := range clusters {
+		info, err := c.getClusterInfo(name)
+		if err != nil {
+			return err
+		}
+		n, err := release.ExportReleases(info.ToRestConfig(), hr.GetReleaseNamespace(), helm.GetReleaseName(hr))
+		if err != nil {
+			klog.Errorf("export release of helmrequest %s to cluster %s error: %s", hr.Name, name, err.Error())
+			return err
+		}
+		count += n
+	}
+
+	annotations := map[string]interface{}{
+		util.ExportHelmReleaseAnnotation: nil,
+	}
+	if hr.Annotations[util.ExportHelmReleaseAnnotation] == exportNoSync {
+		annotations[util.NoSyncAnotation] = "true"
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.getAppClient(hr).AppV1().HelmRequests(hr.Namespace).Patch(hr.Name, types.MergePatchType, data); err != nil {
+		return err
+	}
+
+	c.sendExportedEvent(hr, count)
+	return nil
+}
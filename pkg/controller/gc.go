@@ -0,0 +1,312 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/config"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/release/storagedriver"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	helm_release "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	// ReleaseGCPolicyNone disables the release GC
+	ReleaseGCPolicyNone = "none"
+	// ReleaseGCPolicyReport only sends events for the garbage releases
+	ReleaseGCPolicyReport = "report"
+	// ReleaseGCPolicyClean uninstalls the orphaned releases and deletes the stuck release records
+	ReleaseGCPolicyClean = "clean"
+
+	// OrphanedRelease is the event reason of a release without HelmRequest
+	OrphanedRelease = "OrphanedRelease"
+	// StuckRelease is the event reason of a release stuck in pending status
+	StuckRelease = "StuckRelease"
+	// ReleaseCollected is the event reason of a release cleaned by GC
+	ReleaseCollected = "ReleaseCollected"
+	// FailedCollectRelease is the event reason of a release failed to be cleaned by GC
+	FailedCollectRelease = "FailedCollectRelease"
+)
+
+// stuckStatuses are the statuses a release should not stay in for long
+var stuckStatuses = []helm_release.Status{
+	helm_release.StatusPendingInstall,
+	helm_release.StatusPendingUpgrade,
+	helm_release.StatusPendingRollback,
+	helm_release.StatusUninstalling,
+}
+
+// ReleaseGC finds the releases in one cluster which have no HelmRequest (eg: the HelmRequest was deleted
+// when the cluster was unreachable), and the releases stuck in pending statuses for too long, then report
+// or clean them according to the policy.
+type ReleaseGC struct {
+	controller *Controller
+	cluster    *cluster.Info
+
+	client   clientset.Interface
+	recorder record.EventRecorder
+
+	policy   string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewReleaseGCs create a ReleaseGC for each cluster, they should be added to the manager
+func NewReleaseGCs(c *Controller, opt *config.Options) ([]*ReleaseGC, error) {
+	if opt.ReleaseGCPolicy == ReleaseGCPolicyNone {
+		return nil, nil
+	}
+
+	clusters, err := c.getAllClusters()
+	if err != nil {
+		return nil, err
+	}
+	if !hasCluster(clusters, c.clusterConfig.globalClusterName) {
+		global, _ := c.getClusterInfo("")
+		ci := *global
+		ci.Name = c.clusterConfig.globalClusterName
+		clusters = append(clusters, &ci)
+	}
+
+	var gcs []*ReleaseGC
+	for _, info := range clusters {
+		cfg := info.ToRestConfig()
+		client, err := clientset.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		coreClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		gcs = append(gcs, &ReleaseGC{
+			controller: c,
+			cluster:    info,
+			client:     client,
+			recorder:   newEventRecorder(info.Name, coreClient),
+			policy:     opt.ReleaseGCPolicy,
+			interval:   opt.ReleaseGCInterval,
+			timeout:    opt.PendingReleaseTimeout,
+		})
+	}
+	return gcs, nil
+}
+
+func hasCluster(clusters []*cluster.Info, name string) bool {
+	for _, item := range clusters {
+		if item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start implements manager.Runnable
+func (g *ReleaseGC) Start(ctx context.Context) error {
+	klog.Infof("start release gc for cluster %s, policy: %s", g.cluster.Name, g.policy)
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := g.collect(); err != nil {
+				klog.Errorf("release gc for cluster %s error: %s", g.cluster.Name, err.Error())
+			}
+		}
+	}
+}
+
+// collect runs a round of gc
+func (g *ReleaseGC) collect() error {
+	if !g.cluster.IsReachable() {
+		klog.Warningf("cluster %s is not reachable, skip release gc", g.cluster.Name)
+		return nil
+	}
+
+	owners, err := g.controller.getReleaseOwners()
+	if err != nil {
+		// without all the helmrequests, every release may be seen as orphaned
+		return err
+	}
+
+	// an orphaned release has many versions, only handle it once
+	orphaned := make(map[string]bool)
+
+	driver := storagedriver.NewReleases(g.client.AppV1alpha1().Releases(metav1.NamespaceAll))
+	opts := storagedriver.ListOptions{}
+	for {
+		items, next, err := driver.ListLazy(opts)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			key := releaseOwnerKey(g.cluster.Name, item.Namespace, item.Name)
+			if !owners[key] {
+				if !orphaned[key] {
+					orphaned[key] = true
+					g.handleOrphaned(item)
+				}
+				continue
+			}
+			if g.isStuck(item) {
+				g.handleStuck(item)
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		opts.Continue = next
+	}
+}
+
+// isStuck checks if the release stays in a pending status for longer than the timeout
+func (g *ReleaseGC) isStuck(item *storagedriver.LazyRelease) bool {
+	if item.Info == nil {
+		return false
+	}
+	stuck := false
+	for _, status := range stuckStatuses {
+		if item.Info.Status == status {
+			stuck = true
+		}
+	}
+	if !stuck {
+		return false
+	}
+
+	last := item.Info.LastDeployed
+	if item.Info.Deleted.After(last) {
+		last = item.Info.Deleted
+	}
+	return time.Since(last.Time) > g.timeout
+}
+
+// handleOrphaned uninstalls the release if the policy is clean, the last version is enough to do this
+func (g *ReleaseGC) handleOrphaned(item *storagedriver.LazyRelease) {
+	obj := releaseObject(item)
+	if g.policy != ReleaseGCPolicyClean {
+		g.recorder.Event(obj, corev1.EventTypeWarning, OrphanedRelease,
+			fmt.Sprintf("Release %s/%s in cluster %s has no HelmRequest", item.Namespace, item.Name, g.cluster.Name))
+		return
+	}
+
+	if err := helm.UninstallRelease(g.cluster, item.Namespace, item.Name); err != nil {
+		g.recorder.Event(obj, corev1.EventTypeWarning, FailedCollectRelease,
+			fmt.Sprintf("Uninstall orphaned release %s/%s error: %s", item.Namespace, item.Name, err.Error()))
+		return
+	}
+	g.recorder.Event(obj, corev1.EventTypeNormal, ReleaseCollected,
+		fmt.Sprintf("Uninstalled orphaned release %s/%s", item.Namespace, item.Name))
+}
+
+// handleStuck deletes the stuck release record if the policy is clean, the next sync will handle it
+func (g *ReleaseGC) handleStuck(item *storagedriver.LazyRelease) {
+	obj := releaseObject(item)
+	if g.policy != ReleaseGCPolicyClean {
+		g.recorder.Event(obj, corev1.EventTypeWarning, StuckRelease,
+			fmt.Sprintf("Release %s stays in status %s for more than %s", item.Key, item.Info.Status, g.timeout))
+		return
+	}
+
+	driver := storagedriver.NewReleases(g.client.AppV1alpha1().Releases(item.Namespace))
+	if _, err := driver.Delete(item.Key); err != nil {
+		g.recorder.Event(obj, corev1.EventTypeWarning, FailedCollectRelease,
+			fmt.Sprintf("Delete stuck release %s error: %s", item.Key, err.Error()))
+		return
+	}
+	g.recorder.Event(obj, corev1.EventTypeNormal, ReleaseCollected,
+		fmt.Sprintf("Deleted release %s which stays in status %s for more than %s", item.Key, item.Info.Status, g.timeout))
+}
+
+// releaseObject is the object events are recorded to
+func releaseObject(item *storagedriver.LazyRelease) *v1alpha1.Release {
+	return &v1alpha1.Release{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      item.Key,
+			Namespace: item.Namespace,
+			UID:       item.UID,
+		},
+	}
+}
+
+func releaseOwnerKey(cluster, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", cluster, namespace, name)
+}
+
+// getReleaseOwners lists the helmrequests in all the clusters, and returns the releases they own
+func (c *Controller) getReleaseOwners() (map[string]bool, error) {
+	clusters, err := c.getAllClusters()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range clusters {
+		names = append(names, item.Name)
+		if item.Name == "global" || item.Name == c.clusterConfig.globalClusterName {
+			continue
+		}
+		// helmrequests may also live in other clusters, we can not know all the owners if it's not watched
+		if _, ok := c.clusterClients[item.Name]; !ok {
+			return nil, fmt.Errorf("cluster %s is not watched yet", item.Name)
+		}
+	}
+
+	clients := map[string]clientset.Interface{"": c.appClientSet}
+	for name, client := range c.clusterClients {
+		clients[name] = client
+	}
+
+	var hrs []*appv1.HelmRequest
+	for name, client := range clients {
+		list, err := client.AppV1().HelmRequests(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("list helmrequests in cluster %s error: %s", name, err.Error())
+		}
+		for i := range list.Items {
+			hr := &list.Items[i]
+			hr.ClusterName = name
+			hrs = append(hrs, hr)
+		}
+	}
+
+	return releaseOwners(hrs, names, c.clusterConfig.globalClusterName), nil
+}
+
+// releaseOwners returns the keys (<cluster>/<namespace>/<name>) of the releases owned by the helmrequests.
+// hr.ClusterName is the cluster the helmrequest lives in, "" means the global cluster.
+func releaseOwners(hrs []*appv1.HelmRequest, clusters []string, global string) map[string]bool {
+	owners := make(map[string]bool)
+	for _, hr := range hrs {
+		targets := append([]string{}, hr.Status.SyncedClusters...)
+		if hr.Spec.InstallToAllClusters {
+			targets = append(targets, clusters...)
+		} else {
+			target := hr.Spec.ClusterName
+			if target == "" {
+				target = hr.ClusterName
+			}
+			if target == "" {
+				target = global
+			}
+			targets = append(targets, target)
+		}
+
+		for _, target := range targets {
+			owners[releaseOwnerKey(target, hr.GetReleaseNamespace(), helm.GetReleaseName(hr))] = true
+		}
+	}
+	return owners
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"testing"
+
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReleaseOwners(t *testing.T) {
+	hrs := []*appv1.HelmRequest{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "default"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote", Namespace: "default"},
+			Spec:       appv1.HelmRequestSpec{ClusterName: "c1", Namespace: "apps", ReleaseName: "web"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "all", Namespace: "default", ClusterName: "c2"},
+			Spec:       appv1.HelmRequestSpec{InstallToAllClusters: true},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "member", Namespace: "default", ClusterName: "c2"},
+		},
+	}
+
+	owners := releaseOwners(hrs, []string{"global", "c1", "c2"}, "global")
+	assert.Equal(t, map[string]bool{
+		"global/default/local": true,
+		"c1/apps/web":          true,
+		"global/default/all":   true,
+		"c1/default/all":       true,
+		"c2/default/all":       true,
+		"c2/default/member":    true,
+	}, owners)
+}
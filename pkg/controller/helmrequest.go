@@ -55,19 +55,53 @@ func (c *Controller) syncHandler(key string) error {
 			klog.Info("found helmrequest want to be ignored by captain: ", helmRequest.Name)
 			return nil
 		}
+		if helmRequest.Annotations[util.AgentManagedAnnotation] == "true" {
+			klog.Info("found helmrequest managed by captain agents, ignore it: ", helmRequest.Name)
+			return nil
+		}
 	}
 
 	helmRequest.ClusterName = clusterName
 
 	if !helmRequest.DeletionTimestamp.IsZero() {
 		klog.Infof("HelmRequest has not nil DeletionTimestamp, starting to delete it: %s", helmRequest.Name)
+		// dependents are deleted before their dependencies, which are enqueued when a dependent is gone
+		if err := c.waitForDependents(helmRequest); err != nil {
+			if _, ok := err.(*DependentsError); ok {
+				klog.Infof("HelmRequest %s is %s", helmRequest.Name, err.Error())
+				c.enqueueKey(clusterName, key, dependencyRecheckInterval)
+				return nil
+			}
+			c.sendFailedDeleteEvent(helmRequest, err)
+			return err
+		}
 		if err := c.deleteHelmRequest(helmRequest); err != nil {
+			// the progress is already reported in the Deleting condition
+			if helm.IsWaitingForDeletion(err) {
+				klog.Infof("HelmRequest %s is waiting for resources to be deleted: %s", helmRequest.Name, err.Error())
+				return err
+			}
 			c.sendFailedDeleteEvent(helmRequest, err)
 			return err
 		}
 		return nil
 	}
 
+	// ephemeral helmrequests are deleted when expired, the deletion is handled in the next round
+	if expired, err := c.checkExpiry(helmRequest, key); expired || err != nil {
+		return err
+	}
+
+	// export the release to helm cli's storage if requested, the sync is skipped this time
+	if _, ok := helmRequest.Annotations[util.ExportHelmReleaseAnnotation]; ok {
+		klog.Infof("export release of helmrequest %s to helm storage", helmRequest.Name)
+		if err := c.exportHelmRequest(helmRequest); err != nil {
+			c.sendFailedSyncEvent(helmRequest, err)
+			return err
+		}
+		return nil
+	}
+
 	// add finalizer if needed, cluster name is already set
 	if err := c.addFinalizer(helmRequest); err != nil {
 		klog.Errorf("add finalizer for helmrequest %s error, err is: %+v", helmRequest.Name, err)
@@ -78,16 +112,45 @@ func (c *Controller) syncHandler(key string) error {
 	// check dependencies
 	if err := c.checkDependenciesForHelmRequest(helmRequest); err != nil {
 		klog.Infof("check dependencies for %s not pass, err is : %+v", helmRequest.Name, err)
+		// dependents are enqueued when the dependencies change, rechecking is only a fallback
+		if e, ok := err.(*DependencyError); ok {
+			c.enqueueKey(clusterName, key, e.recheck)
+			return nil
+		}
 		c.sendFailedSyncEvent(helmRequest, err)
 		return err
 	}
 
 	klog.Infof("dependency check pass for HelmRequest %s", helmRequest.GetName())
 
+	// stop retrying until the helmrequest is changed
+	queueKey := key
+	if clusterName != "" {
+		queueKey = clusterKey(key, clusterName)
+	}
+	if c.isStalled(helmRequest, queueKey) {
+		klog.Infof("HelmRequest %s is stalled, skip sync", helmRequest.Name)
+		return nil
+	}
+
 	if !helmRequest.Spec.InstallToAllClusters {
+		// only one cluster is the target, uninstall from the other synced clusters
+		if len(helmRequest.Status.SyncedClusters) > 0 {
+			lingering := c.removeUntargetedClusters(helmRequest, []string{c.currentTarget(helmRequest).Cluster})
+			if len(lingering) != len(helmRequest.Status.SyncedClusters) {
+				helmRequest.Status.SyncedClusters = lingering
+				if err := helm.UpdateHelmRequestStatus(c.getAppClient(helmRequest), helmRequest); err != nil {
+					return err
+				}
+			}
+		}
 
-		if helm.IsHelmRequestSynced(helmRequest) {
+		if helm.IsHelmRequestSynced(helmRequest) && !c.isResyncDue(helmRequest, key) {
 			klog.Infof("HelmRequest %s synced", helmRequest.Name)
+			// the release at the old target may failed to be cleaned up after synced
+			if err := c.cleanupOldTarget(helmRequest); err != nil {
+				return err
+			}
 			if helmRequest.Status.Phase != appv1.HelmRequestSynced {
 				klog.Infof("helm request phase not synced, trying to set it")
 				helmRequest.Status.Reason = ""
@@ -96,17 +159,32 @@ func (c *Controller) syncHandler(key string) error {
 			}
 			return nil
 		}
+		// out of the sync windows, the changes are applied when the next window opens
+		if err := c.checkSyncWindows(helmRequest, c.getDeployCluster(helmRequest)); err != nil {
+			if e, ok := err.(*SyncWindowError); ok {
+				klog.Infof("HelmRequest %s is waiting: %s", helmRequest.Name, e.Error())
+				c.recordWaiting(helmRequest, e)
+				c.enqueueKey(clusterName, key, e.recheck())
+				return nil
+			}
+			c.sendFailedSyncEvent(helmRequest, err)
+			return err
+		}
+		c.recordWaiting(helmRequest, nil)
 		c.setPendingStatus(helmRequest)
+		c.prepareTargetChange(helmRequest)
 		klog.Infof("sync HelmRequest %s to cluster %s", key, helmRequest.Spec.ClusterName)
 		if err := c.syncToCluster(helmRequest); err != nil {
-			c.setSyncFailedStatus(helmRequest, err)
+			return c.retrySync(helmRequest, clusterName, key, err)
+		}
+		if err := c.cleanupOldTarget(helmRequest); err != nil {
 			return err
 		}
 	} else if err := c.syncToAllClusters(key, helmRequest); err != nil {
-		c.setSyncFailedStatus(helmRequest, err)
-		return err
+		return c.retrySync(helmRequest, clusterName, key, err)
 	}
 
+	c.resetRetries(helmRequest, queueKey)
 	// If we send event here, HelmRequest enabled installToAllCluster will send
 	c.getEventRecorder(helmRequest).Event(helmRequest, v1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	return nil
@@ -129,8 +207,11 @@ func (c *Controller) syncToCluster(helmRequest *appv1.HelmRequest) error {
 
 	// Finally, we update the status block of the HelmRequest resource to reflect the
 	// current state of the world
-	err = c.updateHelmRequestSynced(helmRequest)
-	return err
+	if err := c.updateHelmRequestSynced(helmRequest); err != nil {
+		return err
+	}
+	c.recordLastApplied(helmRequest)
+	return nil
 }
 
 // enqueueHelmRequest takes a HelmRequest resource and converts it into a namespace/name
@@ -206,6 +287,7 @@ func (c *Controller) deleteHandler(obj interface{}) {
 	}
 
 	klog.Infof("receive delete event: %+v", hr)
+	c.enqueueDependencies(hr, "")
 
 	outdated, err := c.isOldEvent("", hr)
 	if err != nil {
@@ -233,6 +315,11 @@ func (c *Controller) deleteHandler(obj interface{}) {
 // deleteHelmRequest delete the installed chart created by  this HelmRequest
 // if InstallToAllClusters=true, delete it from all clusters
 func (c *Controller) deleteHelmRequest(hr *appv1.HelmRequest) error {
+	if isAgentManaged(hr) {
+		klog.Infof("helmrequest %s is managed by captain agents, skip delete", hr.Name)
+		return nil
+	}
+
 	// get clusters
 	var clusters []*cluster.Info
 	if hr.Spec.InstallToAllClusters {
@@ -255,6 +342,23 @@ func (c *Controller) deleteHelmRequest(hr *appv1.HelmRequest) error {
 	}
 
 	var errs []error
+	// resources of the release are not gone yet, see DeleteAndWait
+	var waiting []error
+
+	// the release may still exist in the clusters which are not targets any more
+	for _, name := range hr.Status.SyncedClusters {
+		if funk.ContainsString(clusterNames(clusters), name) {
+			continue
+		}
+		info, err := c.getClusterInfo(name)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		clusters = append(clusters, info)
+	}
 
 	// loop to delete in all clusters
 	for _, info := range clusters {
@@ -262,12 +366,26 @@ func (c *Controller) deleteHelmRequest(hr *appv1.HelmRequest) error {
 		ci.Namespace = hr.GetReleaseNamespace()
 		klog.Infof("delete HelmRequest %s for cluster %s", hr.GetName(), ci.Name)
 
-		d := helm.NewDeploy(c.getClusterAppClient(ci.Name))
+		d := helm.NewDeploy(c.getAppClient(hr))
 		d.HelmRequest = hr
 		d.Cluster = &ci
+		d.AllowUnchecked = c.allowUnchecked
 
 		err := d.Delete()
 		if err != nil {
+			if helm.IsWaitingForDeletion(err) {
+				waiting = append(waiting, err)
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// the release at the old target is not cleaned up yet
+	if !hr.Spec.InstallToAllClusters && c.isTargetChanged(hr) {
+		last := lastAppliedTarget(hr)
+		klog.Infof("delete release of HelmRequest %s at old target %s", hr.GetName(), last)
+		if err := c.uninstallTarget(hr, *last, getTargetChangePolicy(hr) != TargetChangeUninstall); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -286,6 +404,17 @@ func (c *Controller) deleteHelmRequest(hr *appv1.HelmRequest) error {
 
 	}
 
+	// keep the finalizer until all the resources are gone
+	if len(waiting) > 0 {
+		return waiting[0]
+	}
+
+	// the outputs of the helmrequests in other clusters have no owner to be garbage collected
+	if err := helm.DeleteOutputs(c.kubeClient, hr); err != nil {
+		klog.Errorf("delete outputs of helmrequest %s error: %s", hr.Name, err.Error())
+		return err
+	}
+
 	if err := c.removeFinalizer(hr); err != nil {
 		return err
 	}
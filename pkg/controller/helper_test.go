@@ -0,0 +1,29 @@
+package controller
+
+import (
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// newHelmRequest returns a helmrequest default/test with the annotations
+func newHelmRequest(annotations map[string]string) *appv1.HelmRequest {
+	return &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "test",
+		Annotations: annotations,
+	}}
+}
+
+// newTestController returns a controller of the global cluster, the objects are served by a fake clientset
+func newTestController(objects ...runtime.Object) *Controller {
+	return &Controller{
+		appClientSet: fake.NewSimpleClientset(objects...),
+		workQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test"),
+		recorder:     record.NewFakeRecorder(100),
+		retries:      make(map[string]*retryState),
+	}
+}
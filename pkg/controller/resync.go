@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// minResyncInterval is the min interval of the periodic resync
+const minResyncInterval = 5 * time.Minute
+
+// getResyncInterval returns the interval of the periodic resync, 0 if it's not enabled
+func getResyncInterval(hr *appv1.HelmRequest) (time.Duration, error) {
+	value := hr.Annotations[util.ResyncIntervalAnnotation]
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid annotation %s: %s", util.ResyncIntervalAnnotation, value)
+	}
+	if interval < minResyncInterval {
+		return minResyncInterval, nil
+	}
+	return interval, nil
+}
+
+// lastApplied returns when the release was last applied, zero if it's not recorded
+func lastApplied(hr *appv1.HelmRequest) time.Time {
+	t, err := time.Parse(time.RFC3339, hr.Annotations[util.LastAppliedAnnotation])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// recordLastApplied records now as the last applied time of the helmrequest with the periodic resync enabled.
+// It's an annotation instead of a condition, so the conditions are not touched by every resync.
+func (c *Controller) recordLastApplied(hr *appv1.HelmRequest) {
+	if hr.Annotations[util.ResyncIntervalAnnotation] == "" {
+		return
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				util.LastAppliedAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		klog.Errorf("marshal last applied time of helmrequest %s error: %s", hr.Name, err.Error())
+		return
+	}
+	// the resync is due earlier if it fails, that's harmless
+	if _, err := c.getAppClient(hr).AppV1().HelmRequests(hr.Namespace).Patch(hr.Name, types.MergePatchType, data); err != nil {
+		klog.Errorf("record last applied time of helmrequest %s error: %s", hr.Name, err.Error())
+	}
+}
+
+// isResyncDue checks if the periodic resync of a synced helmrequest is due. If it's not due yet, the
+// helmrequest is enqueued again when it is.
+func (c *Controller) isResyncDue(hr *appv1.HelmRequest, key string) bool {
+	interval, err := getResyncInterval(hr)
+	if err != nil {
+		klog.Warningf("check resync of helmrequest %s error: %s", hr.Name, err.Error())
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "InvalidResyncInterval", err.Error())
+		return false
+	}
+	if interval == 0 {
+		return false
+	}
+
+	last := lastApplied(hr)
+	if last.IsZero() {
+		return true
+	}
+	if wait := time.Until(last.Add(interval)); wait > 0 {
+		c.enqueueKey(hr.ClusterName, key, wait)
+		return false
+	}
+	klog.Infof("periodic resync of helmrequest %s is due, last applied at %s", hr.Name, last.Format(time.RFC3339))
+	return true
+}
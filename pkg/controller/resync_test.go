@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetResyncInterval(t *testing.T) {
+	intervalOf := func(value string) *appv1.HelmRequest {
+		return newHelmRequest(map[string]string{util.ResyncIntervalAnnotation: value})
+	}
+
+	interval, err := getResyncInterval(&appv1.HelmRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	interval, err = getResyncInterval(intervalOf("24h"))
+	assert.Nil(t, err)
+	assert.Equal(t, 24*time.Hour, interval)
+
+	interval, err = getResyncInterval(intervalOf("10s"))
+	assert.Nil(t, err)
+	assert.Equal(t, minResyncInterval, interval)
+
+	_, err = getResyncInterval(intervalOf("daily"))
+	assert.NotNil(t, err)
+}
+
+func TestRecordLastApplied(t *testing.T) {
+	hr := newHelmRequest(map[string]string{util.ResyncIntervalAnnotation: "1h"})
+	hr.Status.Conditions = []appv1.HelmRequestCondition{{Type: ConditionDependencies}}
+	assert.True(t, lastApplied(hr).IsZero())
+	c := newTestController(hr)
+
+	before := time.Now().Add(-time.Second)
+	c.recordLastApplied(hr)
+	current, err := c.appClientSet.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, lastApplied(current).After(before))
+	assert.Equal(t, hr.Status.Conditions, current.Status.Conditions)
+
+	// not recorded without the periodic resync
+	hr = newHelmRequest(nil)
+	c = newTestController(hr)
+	c.recordLastApplied(hr)
+	current, err = c.appClientSet.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, lastApplied(current).IsZero())
+}
+
+func TestIsResyncDue(t *testing.T) {
+	c := newTestController()
+	resyncAfter := func(interval string, last time.Time) bool {
+		annotations := map[string]string{util.ResyncIntervalAnnotation: interval}
+		if !last.IsZero() {
+			annotations[util.LastAppliedAnnotation] = last.UTC().Format(time.RFC3339)
+		}
+		return c.isResyncDue(newHelmRequest(annotations), "default/test")
+	}
+
+	assert.False(t, c.isResyncDue(newHelmRequest(nil), "default/test"))
+	assert.False(t, resyncAfter("daily", time.Time{}))
+	// never applied
+	assert.True(t, resyncAfter("1h", time.Time{}))
+	assert.False(t, resyncAfter("1h", time.Now().Add(-30*time.Minute)))
+	assert.True(t, resyncAfter("1h", time.Now().Add(-2*time.Hour)))
+}
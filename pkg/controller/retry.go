@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// ConditionStalled reports the sync is stopped retrying, until the helmrequest is changed
+	ConditionStalled appv1.HelmRequestConditionType = "Stalled"
+	// ConditionRetries records the failed attempts of a helmrequest since it's last changed, the message is a json
+	// of retryState
+	ConditionRetries appv1.HelmRequestConditionType = "Retries"
+
+	// defaultMinBackoff and defaultMaxBackoff are the backoff bounds if they are not specified
+	defaultMinBackoff = 5 * time.Second
+	defaultMaxBackoff = 15 * time.Minute
+)
+
+// RetryPolicy decides how to retry the failed syncs of a helmrequest, it's the captain-retry-policy annotation
+type RetryPolicy struct {
+	// MaxAttempts is how many times to sync before stalled, 0 means unlimited
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// MinBackoff is the delay of the first retry, it's doubled for each retry
+	MinBackoff string `json:"minBackoff,omitempty"`
+	// MaxBackoff is the max delay of the retries
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+
+	minBackoff, maxBackoff time.Duration
+}
+
+// getRetryPolicy parses the retry policy of the helmrequest, the default one retries forever
+func getRetryPolicy(hr *appv1.HelmRequest) (*RetryPolicy, error) {
+	policy := &RetryPolicy{minBackoff: defaultMinBackoff, maxBackoff: defaultMaxBackoff}
+	value := hr.Annotations[util.RetryPolicyAnnotation]
+	if value == "" {
+		return policy, nil
+	}
+
+	if err := yaml.Unmarshal([]byte(value), policy); err != nil {
+		return nil, fmt.Errorf("parse annotation %s error: %s", util.RetryPolicyAnnotation, err.Error())
+	}
+	if policy.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid maxAttempts in annotation %s: %d", util.RetryPolicyAnnotation, policy.MaxAttempts)
+	}
+	for _, item := range []struct {
+		value  string
+		target *time.Duration
+	}{{policy.MinBackoff, &policy.minBackoff}, {policy.MaxBackoff, &policy.maxBackoff}} {
+		if item.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(item.value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid backoff in annotation %s: %s", util.RetryPolicyAnnotation, item.value)
+		}
+		*item.target = d
+	}
+	if policy.minBackoff > policy.maxBackoff {
+		return nil, fmt.Errorf("minBackoff is greater than maxBackoff in annotation %s", util.RetryPolicyAnnotation)
+	}
+	return policy, nil
+}
+
+// backoff returns the delay before the next attempt, after the attempts failed
+func (p *RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < attempts && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return d
+}
+
+// retryState is the failed attempts of a helmrequest since it's last changed
+type retryState struct {
+	// Hash is the GenUniqueHash of the helmrequest failed
+	Hash     string `json:"hash"`
+	Attempts int    `json:"attempts"`
+	Stalled  bool   `json:"stalled,omitempty"`
+}
+
+// getRetryState returns the retry state of the helmrequest, it's rebuilt from the Retries condition after
+// captain restarted. Returns nil if it's not failed. The caller must hold retriesLock.
+func (c *Controller) getRetryState(hr *appv1.HelmRequest, key string) *retryState {
+	if state, ok := c.retries[key]; ok {
+		return state
+	}
+	for _, cond := range hr.Status.Conditions {
+		if cond.Type != ConditionRetries || cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		state := &retryState{}
+		if err := json.Unmarshal([]byte(cond.Message), state); err != nil {
+			klog.Warningf("parse retry state of helmrequest %s error: %s", hr.Name, err.Error())
+			return nil
+		}
+		c.retries[key] = state
+		return state
+	}
+	return nil
+}
+
+// isStalled checks if the helmrequest is stalled and not changed since then. The state is reset if it's changed.
+func (c *Controller) isStalled(hr *appv1.HelmRequest, key string) bool {
+	c.retriesLock.Lock()
+	defer c.retriesLock.Unlock()
+	state := c.getRetryState(hr, key)
+	if state == nil {
+		return false
+	}
+	if state.Hash != helm.GenUniqueHash(hr) {
+		delete(c.retries, key)
+		return false
+	}
+	return state.Stalled
+}
+
+// resetRetries forgets the failed attempts after the helmrequest is synced
+func (c *Controller) resetRetries(hr *appv1.HelmRequest, key string) {
+	c.retriesLock.Lock()
+	delete(c.retries, key)
+	c.retriesLock.Unlock()
+
+	if isConditionTrue(hr, ConditionRetries) {
+		c.recordCondition(hr, newCondition(ConditionRetries, "Synced", "", corev1.ConditionFalse))
+	}
+	if isConditionTrue(hr, ConditionStalled) {
+		c.recordCondition(hr, newCondition(ConditionStalled, "Synced", "", corev1.ConditionFalse))
+	}
+}
+
+// retrySync handles a failed sync by the retry policy of the helmrequest: enqueue it again after the backoff, or
+// stop retrying if the error is permanent or the attempts are exhausted. The helmrequest is retried when it's
+// changed after stalled.
+func (c *Controller) retrySync(hr *appv1.HelmRequest, cluster, key string, err error) error {
+	c.setSyncFailedStatus(hr, err)
+
+	policy, perr := getRetryPolicy(hr)
+	if perr != nil {
+		klog.Warningf("get retry policy of helmrequest %s error: %s", hr.Name, perr.Error())
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "InvalidRetryPolicy", perr.Error())
+		policy, _ = getRetryPolicy(&appv1.HelmRequest{})
+	}
+
+	qkey := key
+	if cluster != "" {
+		qkey = clusterKey(key, cluster)
+	}
+	hash := helm.GenUniqueHash(hr)
+	c.retriesLock.Lock()
+	state := c.getRetryState(hr, qkey)
+	if state == nil || state.Hash != hash {
+		state = &retryState{Hash: hash}
+		c.retries[qkey] = state
+	}
+	state.Attempts++
+	attempts := state.Attempts
+
+	var reason, msg string
+	switch {
+	case helm.IsPermanentError(err):
+		reason, msg = "PermanentError", "Permanent error, stop retrying: "+err.Error()
+	case policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts:
+		reason, msg = "RetriesExhausted", fmt.Sprintf("Failed after %d attempts: %s", attempts, err.Error())
+	}
+	state.Stalled = reason != ""
+	data, _ := json.Marshal(state)
+	c.retriesLock.Unlock()
+
+	// persist the state, so the attempts are kept after captain restarted
+	c.recordCondition(hr, newCondition(ConditionRetries, "Failed", string(data), corev1.ConditionTrue))
+
+	if reason != "" {
+		klog.Infof("helmrequest %s is stalled: %s", hr.Name, msg)
+		if c.recordCondition(hr, newCondition(ConditionStalled, reason, msg, corev1.ConditionTrue)) {
+			c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "Stalled", msg)
+		}
+		return nil
+	}
+
+	backoff := policy.backoff(attempts)
+	klog.Infof("retry helmrequest %s after %s, attempts: %d", hr.Name, backoff, attempts)
+	c.enqueueKey(cluster, key, backoff)
+	return nil
+}
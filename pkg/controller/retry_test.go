@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestGetRetryPolicy(t *testing.T) {
+	policyOf := func(value string) *appv1.HelmRequest {
+		return newHelmRequest(map[string]string{util.RetryPolicyAnnotation: value})
+	}
+
+	policy, err := getRetryPolicy(&appv1.HelmRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, policy.MaxAttempts)
+	assert.Equal(t, defaultMinBackoff, policy.backoff(1))
+	assert.Equal(t, defaultMaxBackoff, policy.backoff(100))
+
+	policy, err = getRetryPolicy(policyOf(`{"maxAttempts": 5, "minBackoff": "10s", "maxBackoff": "1m"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, policy.MaxAttempts)
+	assert.Equal(t, 10*time.Second, policy.backoff(1))
+	assert.Equal(t, 20*time.Second, policy.backoff(2))
+	assert.Equal(t, 40*time.Second, policy.backoff(3))
+	assert.Equal(t, time.Minute, policy.backoff(4))
+
+	for _, value := range []string{
+		`maxAttempts: -1`,
+		`minBackoff: soon`,
+		`{"minBackoff": "10m", "maxBackoff": "1m"}`,
+	} {
+		_, err := getRetryPolicy(policyOf(value))
+		assert.NotNil(t, err)
+	}
+}
+
+// delayRecorder records the delays of the retries instead of enqueuing them
+type delayRecorder struct {
+	workqueue.RateLimitingInterface
+	delays []time.Duration
+}
+
+func (q *delayRecorder) AddAfter(item interface{}, duration time.Duration) {
+	q.delays = append(q.delays, duration)
+}
+
+func TestRetrySync(t *testing.T) {
+	const key = "default/test"
+	hr := newHelmRequest(map[string]string{util.RetryPolicyAnnotation: `{"maxAttempts": 3, "minBackoff": "10s"}`})
+	c := newTestController(hr)
+	queue := &delayRecorder{RateLimitingInterface: c.workQueue}
+	c.workQueue = queue
+	client := c.appClientSet.AppV1().HelmRequests("default")
+	failed := errors.New("dial tcp 10.0.0.1:6443: connect: connection refused")
+
+	// the backoff grows
+	for i := 1; i < 3; i++ {
+		assert.Nil(t, c.retrySync(hr, "", key, failed))
+		assert.False(t, c.isStalled(hr, key))
+	}
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second}, queue.delays)
+
+	// stalled after the max attempts
+	assert.Nil(t, c.retrySync(hr, "", key, failed))
+	assert.True(t, c.isStalled(hr, key))
+	assert.Len(t, 2, queue.delays)
+	current, err := client.Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, isConditionTrue(current, ConditionStalled))
+
+	// still stalled after restarted
+	c.retries = make(map[string]*retryState)
+	assert.True(t, c.isStalled(current, key))
+	// retried once changed
+	changed := current.DeepCopy()
+	changed.Spec.Version = "1.0.1"
+	assert.False(t, c.isStalled(changed, key))
+
+	// reset when synced
+	c.resetRetries(current, key)
+	current, err = client.Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.False(t, isConditionTrue(current, ConditionRetries))
+	assert.False(t, isConditionTrue(current, ConditionStalled))
+	assert.False(t, c.isStalled(current, key))
+	assert.Nil(t, c.retrySync(current, "", key, failed))
+	assert.Equal(t, 1, c.retries[key].Attempts)
+
+	// the permanent errors are not retried
+	c.retries = make(map[string]*retryState)
+	assert.Nil(t, c.retrySync(changed, "", key, helm.NewPermanentError(failed)))
+	assert.True(t, c.isStalled(changed, key))
+	assert.Len(t, 3, queue.delays)
+}
@@ -7,13 +7,14 @@ import (
 	"github.com/alauda/captain/pkg/cluster"
 	"github.com/alauda/captain/pkg/helm"
 	"github.com/alauda/captain/pkg/release"
+	"github.com/alauda/captain/pkg/release/storagedriver"
+	"github.com/alauda/captain/pkg/util"
 	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/thoas/go-funk"
 	helm_release "helm.sh/helm/v3/pkg/release"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	kblabels "k8s.io/apimachinery/pkg/labels"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog"
 )
@@ -25,36 +26,68 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *appv1.HelmReques
 		return err
 	}
 
-	var synced []string
 	var errs []error
-	equal := helm.IsHelmRequestSynced(helmRequest)
+	// the periodic resync applies to all the clusters again
+	equal := helm.IsHelmRequestSynced(helmRequest) && !c.isResyncDue(helmRequest, key)
 
+	// clusters which are synced but not targets any more, and failed to be cleaned up
+	names := clusterNames(clusters)
+	lingering := c.removeUntargetedClusters(helmRequest, names)
+
+	synced := append([]string{}, lingering...)
 	// if not equal, we need to update helm status first
 	if !equal {
-		helmRequest.Status.SyncedClusters = make([]string, 0)
+		helmRequest.Status.SyncedClusters = lingering
 		helmRequest.Status.Phase = appv1.HelmRequestPending
 		if err := helm.UpdateHelmRequestStatus(c.getAppClient(helmRequest), helmRequest); err != nil {
 			return err
 		}
-	} else if helmRequest.Status.SyncedClusters != nil {
+	} else {
 		// if hash equal, record synced clusters
-		synced = helmRequest.Status.SyncedClusters
+		for _, name := range helmRequest.Status.SyncedClusters {
+			if funk.ContainsString(names, name) {
+				synced = append(synced, name)
+			}
+		}
 	}
 	klog.Infof("origin synced clusters: %+v", synced)
 
+	count := len(synced) - len(lingering)
+	// whether the release is applied to any cluster in this round
+	applied := false
+	// the clusters out of the sync windows
+	var waiting *SyncWindowError
 	for _, cr := range clusters {
 		if equal && funk.Contains(synced, cr.Name) {
 			continue
 		}
+		if err := c.checkSyncWindows(helmRequest, cr.Name); err != nil {
+			e, ok := err.(*SyncWindowError)
+			if !ok {
+				errs = append(errs, err)
+				continue
+			}
+			klog.Infof("skip sync %s to %s: %s", key, cr.Name, e.Error())
+			if waiting == nil {
+				waiting = &SyncWindowError{Reason: e.Reason, Next: e.Next}
+			} else if !e.Next.IsZero() && (waiting.Next.IsZero() || e.Next.Before(waiting.Next)) {
+				// recheck when the earliest window opens
+				waiting.Next = e.Next
+			}
+			waiting.Clusters = append(waiting.Clusters, cr.Name)
+			continue
+		}
 		klog.Infof("sync %s to cluster %s ....", key, cr.Name)
 		if err = c.sync(cr, helmRequest); err != nil {
 			errs = append(errs, err)
 			klog.Infof("skip sync %s to %s, err is : %s, continue...", key, cr.Name, err.Error())
 			continue
 		}
+		applied = true
 		// avoid duplicates...
 		if !funk.Contains(synced, cr.Name) {
 			synced = append(synced, cr.Name)
+			count++
 		}
 	}
 
@@ -63,10 +96,19 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *appv1.HelmReques
 
 	err = utilerrors.NewAggregate(errs)
 
-	if len(synced) >= len(clusters) {
+	c.recordWaiting(helmRequest, waiting)
+	if waiting != nil {
+		c.enqueueKey(helmRequest.ClusterName, key, waiting.recheck())
+	}
+
+	if applied {
+		// after the status is updated
+		defer c.recordLastApplied(helmRequest)
+	}
+	if count >= len(clusters) {
 		// all synced
 		return c.updateHelmRequestSynced(helmRequest)
-	} else if len(synced) > 0 {
+	} else if count > 0 {
 		// partial synced
 		c.sendFailedSyncEvent(helmRequest, err)
 		return c.setPartialSyncedStatus(helmRequest)
@@ -76,15 +118,6 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *appv1.HelmReques
 
 // sync install/update chart to one cluster
 func (c *Controller) sync(info *cluster.Info, helmRequest *appv1.HelmRequest) error {
-	ci := *info
-	ci.Namespace = helmRequest.GetReleaseNamespace()
-	if err := release.EnsureCRDCreated(info.ToRestConfig()); err != nil {
-		klog.Errorf("sync release crd error: %s", err.Error())
-		return err
-	}
-
-	deploy := helm.NewDeploy(c.getAppClient(helmRequest))
-
 	// found exist release here, this is logic from helm, and we skip the decode part to
 	// avoid OOM. This may be removed in the feature
 	// TODO: may be a bug ,if installToAllCluster, may be get the wrong release.
@@ -98,30 +131,42 @@ func (c *Controller) sync(info *cluster.Info, helmRequest *appv1.HelmRequest) er
 		}
 		return err
 	}
-	options := metav1.ListOptions{
-		LabelSelector: kblabels.Set{"name": helm.GetReleaseName(helmRequest)}.AsSelector().String(),
+
+	err := c.syncRelease(info, helmRequest, client)
+	if err != nil {
+		// another endpoint may be chosen next time
+		info.ForgetEndpointOnError(err)
 	}
-	hist, err := client.AppV1alpha1().Releases(helmRequest.GetReleaseNamespace()).List(options)
-	deployed := false
+	return err
+}
+
+// syncRelease install/update chart to one cluster, client is used to access the Release resources in the
+// target cluster
+func (c *Controller) syncRelease(info *cluster.Info, helmRequest *appv1.HelmRequest, client clientset.Interface) error {
+	ci := *info
+	ci.Namespace = helmRequest.GetReleaseNamespace()
+	if err := release.EnsureCRDCreated(info.ToRestConfig()); err != nil {
+		klog.Errorf("sync release crd error: %s", err.Error())
+		return err
+	}
+
+	// import the release history created by helm cli, so we can take it over instead of reinstall it
+	if isSwitchEnabled(helmRequest, util.ImportHelmReleaseAnnotation) {
+		count, err := release.ImportHelmReleases(info.ToRestConfig(), ci.Namespace, helm.GetReleaseName(helmRequest))
+		if err != nil {
+			klog.Errorf("import helm releases for %s error: %s", helmRequest.Name, err.Error())
+			return err
+		}
+		if count > 0 {
+			c.sendImportedEvent(helmRequest, count)
+		}
+	}
+
+	deploy := helm.NewDeploy(c.getAppClient(helmRequest))
+
+	deployed, err := cleanReleaseHistory(client, helmRequest)
 	if err != nil {
 		klog.Warningf("failed to list all release of helmrequest %s : %+v", helmRequest.Name, err)
-	} else {
-		klog.V(1).Infof("list all release of helmrequest %s, releases length : %d", helmRequest.Name, len(hist.Items))
-		if len(hist.Items) > 0 {
-			for _, item := range hist.Items {
-				if item.Status.Status == helm_release.StatusDeployed {
-					deployed = true
-				}
-
-				// delete pending-install... releases, may be caused by OOM
-				if item.Status.Status != helm_release.StatusDeployed && item.Status.Status != helm_release.StatusSuperseded {
-					deploy.Log.Info("found pending release, planning to delete it", "name", item.Name, "status", item.Status.Status)
-					if err := client.AppV1alpha1().Releases(helmRequest.GetReleaseNamespace()).Delete(item.Name, &metav1.DeleteOptions{}); err != nil {
-						deploy.Log.Error(err, "delete pending release error", "name", item.Name)
-					}
-				}
-			}
-		}
 	}
 
 	inCluster, _ := c.getClusterInfo("")
@@ -130,6 +175,7 @@ func (c *Controller) sync(info *cluster.Info, helmRequest *appv1.HelmRequest) er
 	deploy.Cluster = &ci
 	deploy.InCluster = inCluster
 	deploy.SystemNamespace = c.systemNamespace
+	deploy.AllowUnchecked = c.allowUnchecked
 	deploy.HelmRequest = helmRequest
 
 	rel, err := deploy.Sync()
@@ -137,6 +183,12 @@ func (c *Controller) sync(info *cluster.Info, helmRequest *appv1.HelmRequest) er
 		return err
 	}
 
+	// publish the outputs for the dependents, the release is kept and the next sync writes them again
+	if err := deploy.WriteOutputs(rel); err != nil {
+		klog.Errorf("write outputs of %s error: %s", helmRequest.Name, err.Error())
+		return err
+	}
+
 	// record chart version for un-specified ones
 	msg := fmt.Sprintf("Choose chart version: %s %s", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
 	c.getEventRecorder(helmRequest).Event(helmRequest, corev1.EventTypeNormal, SuccessSynced, msg)
@@ -144,3 +196,59 @@ func (c *Controller) sync(info *cluster.Info, helmRequest *appv1.HelmRequest) er
 	helm.PrintRelease(os.Stdout, rel)
 	return nil
 }
+
+// unfinishedStatuses are the statuses except deployed and superseded. pending-upgrade and pending-rollback are
+// not included, the interrupted version is needed to recover the release, see Deploy.recoverPending
+var unfinishedStatuses = []helm_release.Status{
+	helm_release.StatusUnknown,
+	helm_release.StatusUninstalled,
+	helm_release.StatusUninstalling,
+	helm_release.StatusFailed,
+	helm_release.StatusPendingInstall,
+}
+
+// cleanReleaseHistory checks if the release of the helmrequest has a deployed version, and delete the
+// unfinished versions (pending-install..., may be caused by OOM), except the ones recovered by captain. Releases are selected by labels and
+// not decoded, this is logic from helm, and we skip the decode part to avoid OOM.
+func cleanReleaseHistory(client clientset.Interface, helmRequest *appv1.HelmRequest) (bool, error) {
+	driver := storagedriver.NewReleases(client.AppV1alpha1().Releases(helmRequest.GetReleaseNamespace()))
+	name := helm.GetReleaseName(helmRequest)
+
+	deployed, _, err := driver.ListLazy(storagedriver.ListOptions{
+		Name:     name,
+		Statuses: []helm_release.Status{helm_release.StatusDeployed},
+		Limit:    1,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// page by the continue token, the list is a snapshot so the deleted versions do not shift the pages, and
+	// a version still there after the delete is not read again
+	opts := storagedriver.ListOptions{Name: name, Statuses: unfinishedStatuses}
+	for {
+		items, next, err := driver.ListLazy(opts)
+		if err != nil {
+			return len(deployed) > 0, err
+		}
+		for _, item := range items {
+			// the interrupted versions recovered by captain are kept in the history
+			if helm.IsRecovered(item.Info) {
+				continue
+			}
+			klog.Infof("found pending release %s of helmrequest %s, status: %s, planning to delete it",
+				item.Key, helmRequest.Name, item.Info.Status)
+			// delete by the storage driver, so the chunks of it are also deleted
+			if _, err := driver.Delete(item.Key); err != nil {
+				klog.Errorf("delete pending release %s error: %s", item.Key, err.Error())
+				return len(deployed) > 0, err
+			}
+		}
+		if next == "" {
+			break
+		}
+		opts.Continue = next
+	}
+
+	return len(deployed) > 0, nil
+}
@@ -1,13 +1,19 @@
 package controller
 
 import (
+	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/release/storagedriver"
 	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
 	"github.com/ghodss/yaml"
 	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
 )
 
 func TestHelmRequestDeepCopyHash(t *testing.T) {
@@ -34,3 +40,38 @@ func TestHelmRequestDeepCopyHash(t *testing.T) {
 	}
 	assert.Equal(t, helm.GenHashStr(hr.Spec), helm.GenHashStr(hr.DeepCopy().Spec))
 }
+
+func TestCleanReleaseHistory(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := storagedriver.NewReleases(client.AppV1alpha1().Releases("default"))
+	for i, info := range []*release.Info{
+		{Status: release.StatusSuperseded},
+		{Status: release.StatusDeployed},
+		// marked by the recovery of an interrupted upgrade
+		{Status: release.StatusFailed, Description: "upgrade to version 3 was interrupted, marked as failed by captain"},
+		{Status: release.StatusFailed, Description: "upgrade failed"},
+		{Status: release.StatusPendingInstall},
+	} {
+		rel := &release.Release{
+			Name:      "test",
+			Namespace: "default",
+			Version:   i + 1,
+			Info:      info,
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "test", Version: "0.1.0"}},
+		}
+		assert.Nil(t, store.Create(fmt.Sprintf("test.v%d", rel.Version), rel))
+	}
+
+	deployed, err := cleanReleaseHistory(client, newHelmRequest(nil))
+	assert.Nil(t, err)
+	assert.True(t, deployed)
+
+	var versions []int
+	rels, err := store.Query(map[string]string{"name": "test", "owner": "helm"})
+	assert.Nil(t, err)
+	for _, rel := range rels {
+		versions = append(versions, rel.Version)
+	}
+	sort.Ints(versions)
+	assert.Equal(t, []int{1, 2, 3}, versions)
+}
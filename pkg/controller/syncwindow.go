@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alauda/captain/pkg/syncwindow"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionWaiting reports the sync is waiting for the sync windows, or suspended
+	ConditionWaiting appv1.HelmRequestConditionType = "Waiting"
+
+	// syncWindowsConfigMap is the ConfigMap in the system namespace for the global sync windows, the `windows`
+	// key is the same as the captain-sync-windows annotation, and `suspend: "true"` suspends all the syncs
+	syncWindowsConfigMap = "captain-sync-windows"
+
+	// syncWindowRecheckInterval is the max interval to check the sync windows again, the ConfigMap and the
+	// clusters are not watched
+	syncWindowRecheckInterval = 5 * time.Minute
+)
+
+// SyncWindowError means the sync is not allowed now
+type SyncWindowError struct {
+	// Reason is Suspended or OutOfWindow
+	Reason string
+	// Next is the next time the sync is allowed, zero if it's unknown
+	Next time.Time
+	// Clusters are the clusters not allowed, only for installToAllClusters
+	Clusters []string
+}
+
+func (e *SyncWindowError) Error() string {
+	msg := "sync is suspended"
+	if e.Reason != "Suspended" {
+		msg = "out of sync windows"
+		if e.Next.IsZero() {
+			msg += ", no window opens in a month"
+		} else {
+			msg += ", next allowed at " + e.Next.UTC().Format(time.RFC3339)
+		}
+	}
+	if len(e.Clusters) > 0 {
+		msg += " in clusters: " + strings.Join(e.Clusters, ", ")
+	}
+	return msg
+}
+
+// recheck is when to check the sync windows again
+func (e *SyncWindowError) recheck() time.Duration {
+	if e.Next.IsZero() {
+		return syncWindowRecheckInterval
+	}
+	if d := time.Until(e.Next); d < syncWindowRecheckInterval {
+		return d
+	}
+	return syncWindowRecheckInterval
+}
+
+// checkSyncWindows checks if the helmrequest can be synced to the cluster now, by the global suspend switch and
+// the sync windows of global, the cluster and the helmrequest. Returns a *SyncWindowError if it can't.
+func (c *Controller) checkSyncWindows(hr *appv1.HelmRequest, clusterName string) error {
+	var windows []syncwindow.Window
+
+	cm, err := c.kubeClient.CoreV1().ConfigMaps(c.systemNamespace).Get(context.Background(), syncWindowsConfigMap, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if cm.Data["suspend"] == "true" {
+			return &SyncWindowError{Reason: "Suspended"}
+		}
+		items, err := syncwindow.Parse(cm.Data["windows"])
+		if err != nil {
+			return fmt.Errorf("parse sync windows in configmap %s error: %s", syncWindowsConfigMap, err.Error())
+		}
+		windows = append(windows, items...)
+	}
+
+	if clusterName == "" {
+		clusterName = c.clusterConfig.globalClusterName
+	}
+	if clusterName != "" {
+		info, err := c.getClusterInfo(clusterName)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if info != nil && info.Annotations[util.SyncWindowsAnnotation] != "" {
+			items, err := syncwindow.Parse(info.Annotations[util.SyncWindowsAnnotation])
+			if err != nil {
+				return fmt.Errorf("parse sync windows of cluster %s error: %s", clusterName, err.Error())
+			}
+			windows = append(windows, items...)
+		}
+	}
+
+	if hr.Annotations[util.SyncWindowsAnnotation] != "" {
+		items, err := syncwindow.Parse(hr.Annotations[util.SyncWindowsAnnotation])
+		if err != nil {
+			return fmt.Errorf("parse annotation %s error: %s", util.SyncWindowsAnnotation, err.Error())
+		}
+		windows = append(windows, items...)
+	}
+
+	ok, next, err := syncwindow.Next(windows, time.Now())
+	if err != nil || ok {
+		return err
+	}
+	return &SyncWindowError{Reason: "OutOfWindow", Next: next}
+}
+
+// recordWaiting sets the Waiting condition to the error, or clears it if err is nil
+func (c *Controller) recordWaiting(hr *appv1.HelmRequest, err *SyncWindowError) {
+	if err == nil {
+		// no need to add a condition for the helmrequests never waited
+		if isConditionTrue(hr, ConditionWaiting) {
+			c.recordCondition(hr, newCondition(ConditionWaiting, "InWindow", "", corev1.ConditionFalse))
+		}
+		return
+	}
+	if c.recordCondition(hr, newCondition(ConditionWaiting, err.Reason, err.Error(), corev1.ConditionTrue)) {
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, "WaitingForSyncWindow", err.Error())
+	}
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// ConditionLastAppliedTarget records where the release of a helmrequest was last installed, the message is
+	// a json of releaseTarget
+	ConditionLastAppliedTarget appv1.HelmRequestConditionType = "LastAppliedTarget"
+
+	// TargetChangeUninstall uninstalls the release at the old target after installed at the new one
+	TargetChangeUninstall = "uninstall"
+	// TargetChangeKeep removes the release records at the old target, but keeps the resources
+	TargetChangeKeep = "keep"
+	// TargetChangeMigrate adopts the resources of the old release into the new one, only works when the
+	// cluster is not changed, otherwise it's the same as keep
+	TargetChangeMigrate = "migrate"
+)
+
+// releaseTarget is where a release lives
+type releaseTarget struct {
+	Cluster     string `json:"cluster"`
+	Namespace   string `json:"namespace"`
+	ReleaseName string `json:"releaseName"`
+}
+
+func (t releaseTarget) String() string {
+	return fmt.Sprintf("%s/%s/%s", t.Cluster, t.Namespace, t.ReleaseName)
+}
+
+// getTargetChangePolicy returns what to do with the release at the old target
+func getTargetChangePolicy(hr *appv1.HelmRequest) string {
+	if hr.Annotations != nil && hr.Annotations[util.TargetChangePolicyAnnotation] != "" {
+		return hr.Annotations[util.TargetChangePolicyAnnotation]
+	}
+	return TargetChangeUninstall
+}
+
+// currentTarget returns where the release of the helmrequest should be. The current cluster is named
+// by the global cluster name, so "" and the global cluster name are the same target.
+func (c *Controller) currentTarget(hr *appv1.HelmRequest) releaseTarget {
+	cluster := c.getDeployCluster(hr)
+	if cluster == "" {
+		cluster = c.clusterConfig.globalClusterName
+	}
+	return releaseTarget{
+		Cluster:     cluster,
+		Namespace:   hr.GetReleaseNamespace(),
+		ReleaseName: helm.GetReleaseName(hr),
+	}
+}
+
+// lastAppliedTarget returns the target recorded in the status, nil if not recorded
+func lastAppliedTarget(hr *appv1.HelmRequest) *releaseTarget {
+	for _, cond := range hr.Status.Conditions {
+		if cond.Type != ConditionLastAppliedTarget {
+			continue
+		}
+		var target releaseTarget
+		if err := json.Unmarshal([]byte(cond.Message), &target); err != nil {
+			klog.Warningf("parse last applied target of helmrequest %s error: %s", hr.Name, err.Error())
+			return nil
+		}
+		return &target
+	}
+	return nil
+}
+
+// recordAppliedTarget records the target to the status
+func (c *Controller) recordAppliedTarget(hr *appv1.HelmRequest, target releaseTarget) error {
+	data, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	cond := &appv1.HelmRequestCondition{
+		Type:               ConditionLastAppliedTarget,
+		Status:             corev1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            string(data),
+		LastTransitionTime: &now,
+	}
+	return helm.AddConditionForHelmRequest(cond, hr, c.getAppClient(hr))
+}
+
+// isTargetChanged checks if the cluster, namespace or release name of the helmrequest has been changed since
+// last applied
+func (c *Controller) isTargetChanged(hr *appv1.HelmRequest) bool {
+	last := lastAppliedTarget(hr)
+	return last != nil && *last != c.currentTarget(hr)
+}
+
+// prepareTargetChange adopts the resources of the old release when installing the new one, if the policy is migrate
+func (c *Controller) prepareTargetChange(hr *appv1.HelmRequest) {
+	if !c.isTargetChanged(hr) || getTargetChangePolicy(hr) != TargetChangeMigrate {
+		return
+	}
+	if lastAppliedTarget(hr).Cluster != c.currentTarget(hr).Cluster {
+		return
+	}
+	if hr.Annotations == nil {
+		hr.Annotations = make(map[string]string)
+	}
+	hr.Annotations[util.ForceAdoptResourcesAnnotation] = "true"
+}
+
+// cleanupOldTarget is called after the helmrequest is synced, it handles the release at the old target
+// according to the policy, then record the current target.
+func (c *Controller) cleanupOldTarget(hr *appv1.HelmRequest) error {
+	current := c.currentTarget(hr)
+	last := lastAppliedTarget(hr)
+	if last != nil && *last == current {
+		return nil
+	}
+
+	if last != nil {
+		policy := getTargetChangePolicy(hr)
+		klog.Infof("target of helmrequest %s changed from %s to %s, policy: %s", hr.Name, last, current, policy)
+		if err := c.uninstallTarget(hr, *last, policy != TargetChangeUninstall); err != nil {
+			c.sendFailedSyncEvent(hr, fmt.Errorf("uninstall release at old target %s error: %s", last, err.Error()))
+			return err
+		}
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, TargetChanged,
+			fmt.Sprintf("Moved release from %s to %s, policy: %s", last, current, policy))
+	}
+
+	return c.recordAppliedTarget(hr, current)
+}
+
+// uninstallTarget uninstall the release of the helmrequest at the target
+func (c *Controller) uninstallTarget(hr *appv1.HelmRequest, target releaseTarget, keepResources bool) error {
+	cluster := target.Cluster
+	if cluster == c.clusterConfig.globalClusterName {
+		cluster = ""
+	}
+	info, err := c.getClusterInfo(cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.Warningf("cluster %s not found when uninstall release %s, ignore it", target.Cluster, target)
+			return nil
+		}
+		return err
+	}
+	ci := *info
+	ci.Namespace = target.Namespace
+
+	d := helm.NewDeploy(c.getAppClient(hr))
+	d.HelmRequest = helmRequestAtTarget(hr, target, keepResources)
+	d.Cluster = &ci
+	d.AllowUnchecked = c.allowUnchecked
+	return d.Delete()
+}
+
+// helmRequestAtTarget returns a copy of the helmrequest whose release is at the target, it's deletion policy
+// deletes or orphans the resources
+func helmRequestAtTarget(hr *appv1.HelmRequest, target releaseTarget, keepResources bool) *appv1.HelmRequest {
+	old := hr.DeepCopy()
+	old.Spec.ClusterName = target.Cluster
+	old.Spec.Namespace = target.Namespace
+	old.Spec.ReleaseName = target.ReleaseName
+	if old.Annotations == nil {
+		old.Annotations = make(map[string]string)
+	}
+	// the old release does not block the move
+	old.Annotations[util.DeletionPolicyAnnotation] = helm.DeletionDelete
+	if keepResources {
+		old.Annotations[util.DeletionPolicyAnnotation] = helm.DeletionOrphan
+	}
+	return old
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	commoncache "github.com/patrickmn/go-cache"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// withLastAppliedTarget records the target in the LastAppliedTarget condition of the helmrequest, the message
+// is used as is if it's a string
+func withLastAppliedTarget(hr *appv1.HelmRequest, target interface{}) *appv1.HelmRequest {
+	message, ok := target.(string)
+	if !ok {
+		data, _ := json.Marshal(target)
+		message = string(data)
+	}
+	hr.Status.Conditions = append(hr.Status.Conditions, appv1.HelmRequestCondition{
+		Type:    ConditionLastAppliedTarget,
+		Status:  corev1.ConditionTrue,
+		Message: message,
+	})
+	return hr
+}
+
+func TestPrepareTargetChange(t *testing.T) {
+	c := &Controller{clusterConfig: clusterConfig{globalClusterName: "global"}}
+	last := releaseTarget{Cluster: "business", Namespace: "default", ReleaseName: "test"}
+
+	for _, tc := range []struct {
+		name   string
+		policy string
+		spec   appv1.HelmRequestSpec
+		adopt  bool
+	}{
+		{"migrate namespace", TargetChangeMigrate, appv1.HelmRequestSpec{ClusterName: "business", Namespace: "other"}, true},
+		{"migrate release name", TargetChangeMigrate, appv1.HelmRequestSpec{ClusterName: "business", ReleaseName: "renamed"}, true},
+		{"migrate cluster", TargetChangeMigrate, appv1.HelmRequestSpec{ClusterName: "other"}, false},
+		{"migrate unchanged", TargetChangeMigrate, appv1.HelmRequestSpec{ClusterName: "business"}, false},
+		{"keep namespace", TargetChangeKeep, appv1.HelmRequestSpec{ClusterName: "business", Namespace: "other"}, false},
+		{"uninstall namespace", TargetChangeUninstall, appv1.HelmRequestSpec{ClusterName: "business", Namespace: "other"}, false},
+		{"default release name", "", appv1.HelmRequestSpec{ClusterName: "business", ReleaseName: "renamed"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hr := newHelmRequest(map[string]string{util.TargetChangePolicyAnnotation: tc.policy})
+			hr.Spec = tc.spec
+			withLastAppliedTarget(hr, last)
+			c.prepareTargetChange(hr)
+			assert.Equal(t, tc.adopt, hr.Annotations[util.ForceAdoptResourcesAnnotation] == "true")
+		})
+	}
+
+	// nothing to adopt if the last target is not recorded or malformed
+	for _, hr := range []*appv1.HelmRequest{
+		newHelmRequest(map[string]string{util.TargetChangePolicyAnnotation: TargetChangeMigrate}),
+		withLastAppliedTarget(newHelmRequest(map[string]string{util.TargetChangePolicyAnnotation: TargetChangeMigrate}), "{"),
+	} {
+		hr.Spec.Namespace = "other"
+		assert.False(t, c.isTargetChanged(hr))
+		c.prepareTargetChange(hr)
+		assert.Equal(t, "", hr.Annotations[util.ForceAdoptResourcesAnnotation])
+	}
+}
+
+func TestCleanupOldTarget(t *testing.T) {
+	last := releaseTarget{Cluster: "removed", Namespace: "default", ReleaseName: "test"}
+	newController := func(hr *appv1.HelmRequest) *Controller {
+		c := newTestController(hr)
+		c.clusterConfig = clusterConfig{globalClusterName: "global"}
+		// the clusters are not found, so the old releases are gone with them
+		c.ClusterCache = commoncache.New(time.Minute, time.Minute)
+		return c
+	}
+	recorded := func(c *Controller) *releaseTarget {
+		hr, err := c.appClientSet.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+		assert.Nil(t, err)
+		return lastAppliedTarget(hr)
+	}
+	events := func(c *Controller) []string {
+		var result []string
+		for {
+			select {
+			case e := <-c.recorder.(*record.FakeRecorder).Events:
+				result = append(result, e)
+			default:
+				return result
+			}
+		}
+	}
+
+	for _, policy := range []string{TargetChangeUninstall, TargetChangeKeep, TargetChangeMigrate} {
+		t.Run(policy, func(t *testing.T) {
+			hr := withLastAppliedTarget(newHelmRequest(map[string]string{util.TargetChangePolicyAnnotation: policy}), last)
+			hr.Spec.ClusterName = "business"
+			c := newController(hr)
+			assert.Nil(t, c.cleanupOldTarget(hr))
+			assert.Equal(t, c.currentTarget(hr), *recorded(c))
+
+			result := events(c)
+			assert.Len(t, 1, result)
+			assert.True(t, strings.HasPrefix(result[0], "Normal "+TargetChanged))
+			assert.True(t, strings.HasSuffix(result[0], "policy: "+policy))
+		})
+	}
+
+	// the target is recorded the first time, or when the recorded one is malformed
+	for _, hr := range []*appv1.HelmRequest{
+		newHelmRequest(nil),
+		withLastAppliedTarget(newHelmRequest(nil), "not a target"),
+	} {
+		c := newController(hr)
+		assert.Nil(t, c.cleanupOldTarget(hr))
+		assert.Equal(t, releaseTarget{Cluster: "global", Namespace: "default", ReleaseName: "test"}, *recorded(c))
+		assert.Len(t, 0, events(c))
+	}
+
+	// nothing to do if it's not changed
+	hr := withLastAppliedTarget(newHelmRequest(nil), releaseTarget{Cluster: "global", Namespace: "default", ReleaseName: "test"})
+	hr.Spec.ClusterName = "global"
+	c := newController(hr)
+	assert.Nil(t, c.cleanupOldTarget(hr))
+	assert.Len(t, 0, events(c))
+}
+
+func TestHelmRequestAtTarget(t *testing.T) {
+	hr := newHelmRequest(map[string]string{util.DeletionPolicyAnnotation: helm.DeletionDeleteAndWait})
+	hr.Spec.ClusterName = "business"
+	target := releaseTarget{Cluster: "old", Namespace: "legacy", ReleaseName: "app"}
+
+	old := helmRequestAtTarget(hr, target, false)
+	assert.Equal(t, "old", old.Spec.ClusterName)
+	assert.Equal(t, "legacy", old.Spec.Namespace)
+	assert.Equal(t, "app", old.Spec.ReleaseName)
+	assert.Equal(t, helm.DeletionDelete, old.Annotations[util.DeletionPolicyAnnotation])
+	assert.Equal(t, helm.DeletionOrphan, helmRequestAtTarget(hr, target, true).Annotations[util.DeletionPolicyAnnotation])
+	// the helmrequest itself is not changed
+	assert.Equal(t, "business", hr.Spec.ClusterName)
+	assert.Equal(t, helm.DeletionDeleteAndWait, hr.Annotations[util.DeletionPolicyAnnotation])
+}
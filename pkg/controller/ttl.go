@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// ConditionExpiring reports the helmrequest is going to be deleted by it's TTL
+	ConditionExpiring appv1.HelmRequestConditionType = "Expiring"
+
+	// expiryWarningBefore is how long before the expiry to warn
+	expiryWarningBefore = time.Hour
+)
+
+// getExpiry returns when the helmrequest expires by the captain-ttl and captain-expires-at annotations, the
+// later one wins. It's zero if neither of them is set.
+func getExpiry(hr *appv1.HelmRequest) (time.Time, error) {
+	var expiry time.Time
+	if value := hr.Annotations[util.TTLAnnotation]; value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return expiry, fmt.Errorf("invalid annotation %s: %s", util.TTLAnnotation, value)
+		}
+		expiry = hr.CreationTimestamp.Add(ttl)
+	}
+	if value := hr.Annotations[util.ExpiresAtAnnotation]; value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return expiry, fmt.Errorf("invalid annotation %s: %s", util.ExpiresAtAnnotation, value)
+		}
+		if t.After(expiry) {
+			expiry = t
+		}
+	}
+	return expiry, nil
+}
+
+// checkExpiry deletes the helmrequest if it's expired, and returns true. Otherwise it warns if the expiry is
+// coming, and enqueues the helmrequest again when it's time to warn or delete.
+func (c *Controller) checkExpiry(hr *appv1.HelmRequest, key string) (bool, error) {
+	expiry, err := getExpiry(hr)
+	if err != nil {
+		// the release is kept, the helmrequest is synced as usual
+		klog.Warningf("check expiry of helmrequest %s error: %s", hr.Name, err.Error())
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "InvalidTTL", err.Error())
+		return false, nil
+	}
+	if expiry.IsZero() {
+		return false, nil
+	}
+
+	now := time.Now()
+	if !now.Before(expiry) {
+		klog.Infof("helmrequest %s expired at %s, delete it", hr.Name, expiry.Format(time.RFC3339))
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, "Expired",
+			fmt.Sprintf("HelmRequest expired at %s, deleting it", expiry.UTC().Format(time.RFC3339)))
+		err := c.getAppClient(hr).AppV1().HelmRequests(hr.Namespace).Delete(hr.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return true, err
+		}
+		return true, nil
+	}
+
+	msg := fmt.Sprintf("HelmRequest expires at %s", expiry.UTC().Format(time.RFC3339))
+	warnAt := expiry.Add(-expiryWarningBefore)
+	if now.Before(warnAt) {
+		// the lifetime was extended after the warning
+		if isConditionTrue(hr, ConditionExpiring) {
+			c.recordCondition(hr, newCondition(ConditionExpiring, "Extended", msg, corev1.ConditionFalse))
+		}
+		c.enqueueKey(hr.ClusterName, key, warnAt.Sub(now))
+		return false, nil
+	}
+
+	if c.recordCondition(hr, newCondition(ConditionExpiring, "ExpiringSoon", msg, corev1.ConditionTrue)) {
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, "ExpiringSoon",
+			msg+fmt.Sprintf(", extend it by the annotation %s or %s", util.TTLAnnotation, util.ExpiresAtAnnotation))
+	}
+	c.enqueueKey(hr.ClusterName, key, expiry.Sub(now))
+	return false, nil
+}
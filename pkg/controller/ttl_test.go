@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	"github.com/gsamokovarov/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetExpiry(t *testing.T) {
+	created := time.Date(2021, 9, 14, 0, 0, 0, 0, time.UTC)
+	expiryOf := func(annotations map[string]string) (time.Time, error) {
+		hr := newHelmRequest(annotations)
+		hr.CreationTimestamp = metav1.NewTime(created)
+		return getExpiry(hr)
+	}
+
+	expiry, err := expiryOf(nil)
+	assert.Nil(t, err)
+	assert.True(t, expiry.IsZero())
+
+	expiry, err = expiryOf(map[string]string{util.TTLAnnotation: "72h"})
+	assert.Nil(t, err)
+	assert.Equal(t, created.Add(72*time.Hour), expiry)
+
+	// extended by expires-at
+	expiry, err = expiryOf(map[string]string{
+		util.TTLAnnotation:       "72h",
+		util.ExpiresAtAnnotation: "2021-09-20T00:00:00Z",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 9, 20, 0, 0, 0, 0, time.UTC), expiry.UTC())
+
+	// the ttl is later
+	expiry, err = expiryOf(map[string]string{
+		util.TTLAnnotation:       "240h",
+		util.ExpiresAtAnnotation: "2021-09-20T00:00:00Z",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, created.Add(240*time.Hour), expiry)
+
+	_, err = expiryOf(map[string]string{util.TTLAnnotation: "3 days"})
+	assert.NotNil(t, err)
+	_, err = expiryOf(map[string]string{util.ExpiresAtAnnotation: "2021-09-20"})
+	assert.NotNil(t, err)
+}
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Now()
+	expired := newHelmRequest(map[string]string{util.TTLAnnotation: "1h"})
+	expired.Name = "expired"
+	expired.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	live := newHelmRequest(map[string]string{util.TTLAnnotation: "72h"})
+	live.Name = "live"
+	live.CreationTimestamp = metav1.NewTime(now)
+	expiring := newHelmRequest(map[string]string{util.TTLAnnotation: "30m"})
+	expiring.Name = "expiring"
+	expiring.CreationTimestamp = metav1.NewTime(now)
+	c := newTestController(expired, live, expiring)
+	client := c.appClientSet.AppV1().HelmRequests("default")
+
+	deleted, err := c.checkExpiry(expired, "default/expired")
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, err = client.Get("expired", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+
+	deleted, err = c.checkExpiry(live, "default/live")
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+	current, err := client.Get("live", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, 0, current.Status.Conditions)
+
+	deleted, err = c.checkExpiry(expiring, "default/expiring")
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+	current, err = client.Get("expiring", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, isConditionTrue(current, ConditionExpiring))
+}
@@ -0,0 +1,225 @@
+package dependency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Requirement is what a HelmRequest requires from one of it's dependencies
+type Requirement string
+
+const (
+	// RequireSynced requires the dependency is synced to the target cluster
+	RequireSynced Requirement = "Synced"
+	// RequireReady requires the dependency is synced, and all of it's resources are ready, just like helm --wait
+	RequireReady Requirement = "Ready"
+	// RequireHealthy requires the dependency is ready, it's jobs are completed, and it has no pending updates
+	RequireHealthy Requirement = "Healthy"
+)
+
+// Dependency is an edge of the dependency graph
+type Dependency struct {
+	// Cluster is the cluster the dependency lives in, empty means the same cluster as the dependent
+	Cluster     string
+	Namespace   string
+	Name        string
+	Requirement Requirement
+	// Implicit means the dependency comes from valuesFrom referring to the outputs of another HelmRequest, it's
+	// ignored if that HelmRequest does not exist
+	Implicit bool
+}
+
+// Key returns the key of the dependency in the graph, prefixed with the cluster if it's in another cluster
+func (d Dependency) Key() string {
+	if d.Cluster != "" {
+		return ClusterKey(d.Cluster, d.Namespace, d.Name)
+	}
+	return Key(d.Namespace, d.Name)
+}
+
+func (d Dependency) String() string {
+	return fmt.Sprintf("%s(%s)", d.Key(), d.Requirement)
+}
+
+// Key returns the key of a HelmRequest in the graph
+func Key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// ClusterKey returns the key of a HelmRequest in the graph of another cluster
+func ClusterKey(cluster, namespace, name string) string {
+	return cluster + "/" + Key(namespace, name)
+}
+
+// Parse returns the dependencies of the HelmRequest. The ones in .spec.dependencies are in the
+// same namespace and require Synced. The ones in the captain-dependencies annotation are comma separated
+// [[<cluster>/]<namespace>/]<name>[:<requirement>], the cluster defaults to the HelmRequest's, the namespace
+// defaults to the HelmRequest's, the requirement defaults to Synced. If a dependency appears in both, the annotation wins. A valuesFrom referring to the outputs of
+// another HelmRequest, <name>-outputs, is an implicit dependency requires Synced, unless it's declared explicitly.
+func Parse(hr *appv1.HelmRequest) ([]Dependency, error) {
+	var deps []Dependency
+	index := make(map[string]int)
+	add := func(dep Dependency) {
+		if i, ok := index[dep.Key()]; ok {
+			deps[i] = dep
+			return
+		}
+		index[dep.Key()] = len(deps)
+		deps = append(deps, dep)
+	}
+
+	for _, name := range hr.Spec.Dependencies {
+		add(Dependency{Namespace: hr.Namespace, Name: name, Requirement: RequireSynced})
+	}
+
+	value := ""
+	if hr.Annotations != nil {
+		value = hr.Annotations[util.DependenciesAnnotation]
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		dep, err := parseDependency(hr.Namespace, item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependency %q in annotation %s: %s", item, util.DependenciesAnnotation, err.Error())
+		}
+		add(dep)
+	}
+
+	for _, source := range hr.Spec.ValuesFrom {
+		name := ""
+		if source.ConfigMapKeyRef != nil {
+			name = source.ConfigMapKeyRef.Name
+		} else if source.SecretKeyRef != nil {
+			name = source.SecretKeyRef.Name
+		}
+		if !strings.HasSuffix(name, helm.OutputsSuffix) {
+			continue
+		}
+		name = strings.TrimSuffix(name, helm.OutputsSuffix)
+		if name == "" || name == hr.Name {
+			continue
+		}
+		if _, ok := index[Key(hr.Namespace, name)]; !ok {
+			add(Dependency{Namespace: hr.Namespace, Name: name, Requirement: RequireSynced, Implicit: true})
+		}
+	}
+	return deps, nil
+}
+
+func parseDependency(namespace, item string) (Dependency, error) {
+	dep := Dependency{Namespace: namespace, Requirement: RequireSynced}
+	if i := strings.LastIndex(item, ":"); i >= 0 {
+		dep.Requirement = Requirement(item[i+1:])
+		item = item[:i]
+	}
+	switch dep.Requirement {
+	case RequireSynced, RequireReady, RequireHealthy:
+	default:
+		return dep, fmt.Errorf("unknown requirement %s", dep.Requirement)
+	}
+
+	parts := strings.Split(item, "/")
+	switch len(parts) {
+	case 1:
+		dep.Name = parts[0]
+	case 2:
+		dep.Namespace, dep.Name = parts[0], parts[1]
+	case 3:
+		dep.Cluster, dep.Namespace, dep.Name = parts[0], parts[1], parts[2]
+		if errs := validation.IsDNS1123Subdomain(dep.Cluster); len(errs) > 0 {
+			return dep, fmt.Errorf("%s: %s", dep.Cluster, strings.Join(errs, ","))
+		}
+	default:
+		return dep, fmt.Errorf("too many segments")
+	}
+	for _, s := range []string{dep.Namespace, dep.Name} {
+		if errs := validation.IsDNS1123Subdomain(s); len(errs) > 0 {
+			return dep, fmt.Errorf("%s: %s", s, strings.Join(errs, ","))
+		}
+	}
+	return dep, nil
+}
+
+// Graph is the dependency graph of the HelmRequests in a cluster, the dependencies in other clusters are
+// leaves since their dependencies are not known
+type Graph struct {
+	edges map[string][]Dependency
+}
+
+// NewGraph builds the graph, HelmRequests with invalid dependencies are skipped
+func NewGraph(hrs []*appv1.HelmRequest) *Graph {
+	g := &Graph{edges: make(map[string][]Dependency)}
+	for _, hr := range hrs {
+		deps, err := Parse(hr)
+		if err != nil {
+			continue
+		}
+		g.Set(Key(hr.Namespace, hr.Name), deps)
+	}
+	return g
+}
+
+// Set replaces the dependencies of a HelmRequest
+func (g *Graph) Set(key string, deps []Dependency) {
+	g.edges[key] = deps
+}
+
+// FindCycle returns a cycle reachable from the key, like [a b a], or nil if there is none
+func (g *Graph) FindCycle(key string) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(key string) []string
+	visit = func(key string) []string {
+		switch state[key] {
+		case visiting:
+			for i, item := range path {
+				if item == key {
+					return append(append([]string{}, path[i:]...), key)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[key] = visiting
+		path = append(path, key)
+		for _, dep := range g.edges[key] {
+			if cycle := visit(dep.Key()); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = visited
+		return nil
+	}
+	return visit(key)
+}
+
+// Dependents returns the keys of the HelmRequests which depend on the key directly, sorted
+func (g *Graph) Dependents(key string) []string {
+	var result []string
+	for from, deps := range g.edges {
+		for _, dep := range deps {
+			if dep.Key() == key {
+				result = append(result, from)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,73 @@
+package dependency
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newHelmRequest(namespace, name string, deps []string, annotation string) *appv1.HelmRequest {
+	hr := &appv1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       appv1.HelmRequestSpec{Dependencies: deps},
+	}
+	if annotation != "" {
+		hr.Annotations = map[string]string{util.DependenciesAnnotation: annotation}
+	}
+	return hr
+}
+
+func TestParse(t *testing.T) {
+	hr := newHelmRequest("default", "web", []string{"redis", "mysql"}, "db/postgres:Ready, mysql:Healthy, infra/istio-system/istiod:Ready")
+	deps, err := Parse(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, []Dependency{
+		{Namespace: "default", Name: "redis", Requirement: RequireSynced},
+		{Namespace: "default", Name: "mysql", Requirement: RequireHealthy},
+		{Namespace: "db", Name: "postgres", Requirement: RequireReady},
+		{Cluster: "infra", Namespace: "istio-system", Name: "istiod", Requirement: RequireReady},
+	}, deps)
+	assert.Equal(t, "infra/istio-system/istiod(Ready)", deps[3].String())
+
+	_, err = Parse(newHelmRequest("default", "web", nil, "mysql:Running"))
+	assert.NotNil(t, err)
+
+	_, err = Parse(newHelmRequest("default", "web", nil, "db/My_SQL"))
+	assert.NotNil(t, err)
+
+	_, err = Parse(newHelmRequest("default", "web", nil, "a/b/c/d"))
+	assert.NotNil(t, err)
+
+	hr = newHelmRequest("default", "web", []string{"redis"}, "")
+	hr.Spec.ValuesFrom = []appv1.ValuesFromSource{
+		{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "redis-outputs"}}},
+		{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mysql-outputs"}}},
+		{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "web-values"}}},
+	}
+	deps, err = Parse(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, []Dependency{
+		{Namespace: "default", Name: "redis", Requirement: RequireSynced},
+		{Namespace: "default", Name: "mysql", Requirement: RequireSynced, Implicit: true},
+	}, deps)
+}
+
+func TestFindCycle(t *testing.T) {
+	graph := NewGraph([]*appv1.HelmRequest{
+		newHelmRequest("default", "web", []string{"api"}, ""),
+		newHelmRequest("default", "api", nil, "db/mysql:Ready"),
+		newHelmRequest("db", "mysql", nil, ""),
+	})
+	assert.Nil(t, graph.FindCycle("default/web"))
+	assert.Equal(t, []string{"default/api"}, graph.Dependents("db/mysql"))
+
+	graph.Set("db/mysql", []Dependency{{Namespace: "default", Name: "web", Requirement: RequireSynced}})
+	assert.Equal(t, []string{"db/mysql", "default/web", "default/api", "db/mysql"}, graph.FindCycle("db/mysql"))
+
+	graph.Set("db/mysql", []Dependency{{Namespace: "db", Name: "mysql", Requirement: RequireSynced}})
+	assert.Equal(t, []string{"db/mysql", "db/mysql"}, graph.FindCycle("db/mysql"))
+}
@@ -0,0 +1,185 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// GeneratorLabel is the label of the generated HelmRequests, the value is the name of the generator
+const GeneratorLabel = "captain.cpaas.io/generator"
+
+// Params is a set of parameters to render the template
+type Params map[string]string
+
+// paramPattern matches {{<name>}} in the template, spaces around the name are allowed
+var paramPattern = regexp.MustCompile(`{{\s*([\w.\-/]+)\s*}}`)
+
+// ListParams returns the elements of the list
+func ListParams(g *bundlev1alpha1.ListGenerator) []Params {
+	var result []Params
+	for _, item := range g.Elements {
+		params := Params{}
+		for k, v := range item {
+			params[k] = v
+		}
+		result = append(result, params)
+	}
+	return result
+}
+
+// ClusterParams returns the parameters name, endpoint and label.<key> of the clusters selected
+func ClusterParams(g *bundlev1alpha1.ClusterGenerator, clusters []*cluster.Info) ([]Params, error) {
+	selector := labels.Everything()
+	if g.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(g.Selector)
+		if err != nil {
+			return nil, err
+		}
+		selector = s
+	}
+
+	var result []Params
+	for _, item := range clusters {
+		if !selector.Matches(labels.Set(item.Labels)) {
+			continue
+		}
+		params := Params{
+			"name":     item.Name,
+			"endpoint": item.Endpoint,
+		}
+		for k, v := range item.Labels {
+			params["label."+k] = v
+		}
+		result = append(result, params)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i]["name"] < result[j]["name"] })
+	return result, nil
+}
+
+// ChartParams returns the parameters chart(<repo>/<name>), chart.name, chart.version(the latest one) and repo
+// of the charts in a ChartRepo selected by the patterns
+func ChartParams(g *bundlev1alpha1.GitGenerator, charts []v1beta1.Chart) ([]Params, error) {
+	var result []Params
+	for _, item := range charts {
+		if len(item.Spec.Versions) == 0 {
+			continue
+		}
+		name := item.Spec.Versions[0].Name
+		matched := len(g.Charts) == 0
+		for _, pattern := range g.Charts {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %s", pattern, err.Error())
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		result = append(result, Params{
+			"chart":         g.ChartRepo + "/" + name,
+			"chart.name":    name,
+			"chart.version": item.Spec.Versions[0].Version,
+			"repo":          g.ChartRepo,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i]["chart"] < result[j]["chart"] })
+	return result, nil
+}
+
+// Render generates a HelmRequest for each set of the parameters. {{<name>}} in all the strings of the template
+// are replaced, unknown parameters are kept as is. The generated names must be valid and unique.
+func Render(g *bundlev1alpha1.HelmRequestGenerator, params []Params) ([]*appv1.HelmRequest, error) {
+	data, err := json.Marshal(g.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+	var tmpl interface{}
+	if err := json.Unmarshal(data, &tmpl); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	var result []*appv1.HelmRequest
+	for _, p := range params {
+		data, err := json.Marshal(replace(tmpl, p))
+		if err != nil {
+			return nil, err
+		}
+		var rendered bundlev1alpha1.HelmRequestTemplate
+		if err := json.Unmarshal(data, &rendered); err != nil {
+			return nil, err
+		}
+
+		name := rendered.Metadata.Name
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid name %q rendered: %s", name, strings.Join(errs, ","))
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicated name %s rendered", name)
+		}
+		names[name] = true
+
+		hr := &appv1.HelmRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   g.Namespace,
+				Labels:      rendered.Metadata.Labels,
+				Annotations: rendered.Metadata.Annotations,
+			},
+			Spec: rendered.Spec,
+		}
+		if hr.Labels == nil {
+			hr.Labels = make(map[string]string)
+		}
+		hr.Labels[GeneratorLabel] = g.Name
+		// the helmrequest is deployed with the permissions of the generator's creator
+		util.CopyCreator(g, hr)
+		result = append(result, hr)
+	}
+	return result, nil
+}
+
+// replace returns a copy of the json value with the parameters replaced in all the strings
+func replace(value interface{}, params Params) interface{} {
+	switch v := value.(type) {
+	case string:
+		return paramPattern.ReplaceAllStringFunc(v, func(s string) string {
+			key := paramPattern.FindStringSubmatch(s)[1]
+			if p, ok := params[key]; ok {
+				return p
+			}
+			return s
+		})
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = replace(item, params)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = replace(item, params)
+		}
+		return result
+	default:
+		return v
+	}
+}
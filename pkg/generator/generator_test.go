@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"testing"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/cluster"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1beta1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClusterParams(t *testing.T) {
+	clusters := []*cluster.Info{
+		{Name: "prod-2", Endpoint: "https://10.0.0.2", Labels: map[string]string{"env": "prod"}},
+		{Name: "dev", Endpoint: "https://10.0.0.3", Labels: map[string]string{"env": "dev"}},
+		{Name: "prod-1", Endpoint: "https://10.0.0.1", Labels: map[string]string{"env": "prod", "region": "east"}},
+	}
+	params, err := ClusterParams(&bundlev1alpha1.ClusterGenerator{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+	}, clusters)
+	assert.Nil(t, err)
+	assert.Equal(t, []Params{
+		{"name": "prod-1", "endpoint": "https://10.0.0.1", "label.env": "prod", "label.region": "east"},
+		{"name": "prod-2", "endpoint": "https://10.0.0.2", "label.env": "prod"},
+	}, params)
+}
+
+func TestChartParams(t *testing.T) {
+	newChart := func(name, version string) v1beta1.Chart {
+		return v1beta1.Chart{Spec: v1beta1.ChartSpec{Versions: []*v1beta1.ChartVersion{
+			{ChartVersion: repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}}},
+		}}}
+	}
+	charts := []v1beta1.Chart{newChart("svc-b", "0.2.0"), newChart("svc-a", "0.1.0"), newChart("tools", "1.0.0")}
+
+	params, err := ChartParams(&bundlev1alpha1.GitGenerator{ChartRepo: "apps", Charts: []string{"svc-*"}}, charts)
+	assert.Nil(t, err)
+	assert.Equal(t, []Params{
+		{"chart": "apps/svc-a", "chart.name": "svc-a", "chart.version": "0.1.0", "repo": "apps"},
+		{"chart": "apps/svc-b", "chart.name": "svc-b", "chart.version": "0.2.0", "repo": "apps"},
+	}, params)
+}
+
+func TestRender(t *testing.T) {
+	g := &bundlev1alpha1.HelmRequestGenerator{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Namespace: "ops"},
+		Spec: bundlev1alpha1.HelmRequestGeneratorSpec{
+			Template: bundlev1alpha1.HelmRequestTemplate{
+				Metadata: bundlev1alpha1.HelmRequestTemplateMeta{
+					Name:   "monitoring-{{ name }}",
+					Labels: map[string]string{"region": "{{label.region}}"},
+				},
+				Spec: appv1.HelmRequestSpec{
+					ClusterName: "{{name}}",
+					Chart:       "stable/prometheus",
+					HelmValues: appv1.HelmValues{Values: chartutil.Values{
+						"cluster":  map[string]interface{}{"name": "{{name}}", "unknown": "{{foo}}"},
+						"replicas": 2,
+					}},
+				},
+			},
+		},
+	}
+
+	hrs, err := Render(g, []Params{{"name": "prod-1", "label.region": "east"}})
+	assert.Nil(t, err)
+	assert.Len(t, 1, hrs)
+	assert.Equal(t, "monitoring-prod-1", hrs[0].Name)
+	assert.Equal(t, "ops", hrs[0].Namespace)
+	assert.Equal(t, map[string]string{"region": "east", GeneratorLabel: "monitoring"}, hrs[0].Labels)
+	assert.Equal(t, "prod-1", hrs[0].Spec.ClusterName)
+	assert.Equal(t, map[string]interface{}{"name": "prod-1", "unknown": "{{foo}}"}, hrs[0].Spec.Values["cluster"])
+	assert.Equal(t, float64(2), hrs[0].Spec.Values["replicas"])
+
+	_, err = Render(g, []Params{{"name": "prod-1"}, {"name": "prod-1"}})
+	assert.NotNil(t, err)
+
+	_, err = Render(g, []Params{{"name": "Prod_1"}})
+	assert.NotNil(t, err)
+}
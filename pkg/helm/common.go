@@ -49,14 +49,46 @@ func GenHashStr(data interface{}) string {
 	return s
 }
 
-// GenUniqueHash generate a unique hash for a HelmRequest
+// unhashedAnnotations are set by captain itself, they are not included in the hash of the HelmRequest and
+// do not trigger an upgrade
+var unhashedAnnotations = []string{
+	util.ExportHelmReleaseAnnotation,
+	util.LastAppliedAnnotation,
+	util.ModifierAnnotation,
+	util.ModifierGroupsAnnotation,
+}
+
+func isUnhashedAnnotation(key string) bool {
+	for _, item := range unhashedAnnotations {
+		if item == key {
+			return true
+		}
+	}
+	return false
+}
+
+// GenUniqueHash generate a unique hash for a HelmRequest. The annotations set by captain are excluded.
 func GenUniqueHash(hr *appv1.HelmRequest) string {
+	var annotations map[string]string
+	for k, v := range hr.Annotations {
+		if isUnhashedAnnotation(k) {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[k] = v
+	}
+	// keep it as is if nothing excluded, an empty map is hashed differently from nil
+	if len(annotations) == len(hr.Annotations) {
+		annotations = hr.Annotations
+	}
 	source := struct {
 		spec        appv1.HelmRequestSpec
 		annotations map[string]string
 	}{
 		hr.Spec,
-		hr.Annotations,
+		annotations,
 	}
 	return GenHashStr(source)
 }
@@ -0,0 +1,31 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenUniqueHashIgnoresExport(t *testing.T) {
+	hr := &appv1.HelmRequest{Spec: appv1.HelmRequestSpec{Chart: "stable/nginx"}}
+	hash := GenUniqueHash(hr)
+
+	exported := hr.DeepCopy()
+	exported.Annotations = map[string]string{util.ExportHelmReleaseAnnotation: "true"}
+	assert.Equal(t, hash, GenUniqueHash(exported))
+
+	hr.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{"a": "b"}}
+	exported.Annotations["a"] = "b"
+	assert.Equal(t, GenUniqueHash(hr), GenUniqueHash(exported))
+	assert.NotEqual(t, hash, GenUniqueHash(hr))
+}
+
+func TestGenUniqueHashIgnoresLastApplied(t *testing.T) {
+	hr := &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.ResyncIntervalAnnotation: "24h"}}}
+	applied := hr.DeepCopy()
+	applied.Annotations[util.LastAppliedAnnotation] = "2021-09-14T00:00:00Z"
+	assert.Equal(t, GenUniqueHash(hr), GenUniqueHash(applied))
+}
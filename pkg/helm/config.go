@@ -28,11 +28,29 @@ func getNamespace(flags *genericclioptions.ConfigFlags) string {
 // allNamespaces is always set to false for now,
 // default storage driver is Release now
 func (d *Deploy) newActionConfig() (*action.Configuration, error) {
-	restClientGetter := newConfigFlags(d.Cluster.ToRestConfig(), d.Cluster.Namespace, true)
-	kubeClient := &kube.Client{
+	// resources are applied by the ServiceAccount if specified, releases are still stored by the admin
+	deployCluster := d.Cluster
+	sa := getServiceAccountName(d.HelmRequest)
+	if sa != "" {
+		ci, err := serviceAccountCluster(d.Cluster, sa)
+		if err != nil {
+			return nil, err
+		}
+		deployCluster = ci
+	}
+
+	restClientGetter := newConfigFlags(deployCluster.ToRestConfig(), d.Cluster.Namespace, true)
+	var kubeClient kube.Interface = &kube.Client{
 		Factory: util.NewFactory(restClientGetter),
 		Log:     klog.Infof,
 	}
+	if sa != "" {
+		rbac := newServiceAccountRbacClient(d.Cluster.Namespace, sa)
+		rbac.config = d.Cluster.ToRestConfig()
+		rbac.clientGetter = restClientGetter
+		kubeClient = &rbacKubeClient{Interface: kubeClient, rbac: []*RbacClient{rbac}}
+		klog.Infof("deploy helmrequest %s with serviceaccount %s/%s", d.HelmRequest.GetName(), d.Cluster.Namespace, sa)
+	}
 
 	relClientSet, err := releaseclient.NewForConfig(d.Cluster.ToRestConfig())
 	if err != nil {
@@ -54,18 +72,31 @@ func (d *Deploy) newActionConfig() (*action.Configuration, error) {
 		panic("Unknown driver in HELM_DRIVER: " + os.Getenv("HELM_DRIVER"))
 	}
 
-	d.rbacClient = &RbacClient{
-		config:       d.Cluster.ToRestConfig(),
-		clientGetter: restClientGetter,
-	}
 	d.Releases = store
-
-	return &action.Configuration{
+	cfg := &action.Configuration{
 		RESTClientGetter: restClientGetter,
 		KubeClient:       kubeClient,
 		Releases:         store,
 		Log:              klog.Infof,
-	}, nil
+	}
+
+	// check the permissions of the creator and the modifier. The uninstall on deletion is not checked, a user
+	// lost the permissions should not block the finalizer.
+	deleting := d.HelmRequest.DeletionTimestamp != nil
+	d.rbacClients, err = newRbacClients(d.HelmRequest, d.AllowUnchecked || deleting)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.rbacClients) > 0 {
+		for _, r := range d.rbacClients {
+			r.config = d.Cluster.ToRestConfig()
+			r.clientGetter = restClientGetter
+			klog.Infof("check permissions of user %s for helmrequest %s", r.user, d.HelmRequest.GetName())
+		}
+		cfg.KubeClient = &rbacKubeClient{Interface: kubeClient, rbac: d.rbacClients, skipDelete: deleting}
+	}
+
+	return cfg, nil
 }
 
 func newConfigFlags(config *rest.Config, namespace string, insecure bool) *genericclioptions.ConfigFlags {
@@ -75,5 +106,12 @@ func newConfigFlags(config *rest.Config, namespace string, insecure bool) *gener
 		CAFile:      &config.CAFile,
 		BearerToken: &config.BearerToken,
 		Insecure:    &insecure,
+		// clusters registered by kubeconfig may carry ca and client certificates data, which cannot be
+		// set by flags. The client certificates are dropped from the ServiceAccount clusters on purpose, they
+		// take precedence over the token, see serviceAccountCluster
+		WrapConfigFn: func(c *rest.Config) *rest.Config {
+			c.TLSClientConfig = config.TLSClientConfig
+			return c
+		},
 	}
 }
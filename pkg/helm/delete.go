@@ -1,10 +1,12 @@
 package helm
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
-	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/captain/pkg/cluster"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
 	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
@@ -24,12 +26,31 @@ func (d *Deploy) Delete() error {
 		return err
 	}
 
+	// resources matching the retention rules are annotated instead of deleted
+	r, err := getRetention(hr)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		d.retention = r
+		cfg.KubeClient = &retainKubeClient{
+			Interface: cfg.KubeClient,
+			retention: r,
+			from:      fmt.Sprintf("%s/%s", hr.GetReleaseNamespace(), name),
+		}
+	}
+
+	policy := GetDeletionPolicy(hr)
+	if policy == DeletionDeleteAndWait {
+		return d.deleteAndWait(cfg)
+	}
+
 	client := action.NewUninstall(cfg)
 	client.Timeout = 60 * time.Second
 
-	client.KeepResources = isSwitchEnabled(hr, util.KeepResourcesAnnotation)
+	client.KeepResources = policy == DeletionOrphan
 	if client.KeepResources {
-		d.Log.Info("found keep resources in helmrequest annotation, will keep k8s resources when uninstall current release ", "name", name)
+		d.Log.Info("found orphan deletion policy, will keep k8s resources when uninstall current release ", "name", name)
 	}
 
 	res, err := client.Run(name)
@@ -65,6 +86,28 @@ func (d *Deploy) Delete() error {
 	return nil
 }
 
+// UninstallRelease uninstall a release which has no HelmRequest, eg: the orphaned releases found by gc
+func UninstallRelease(info *cluster.Info, namespace, name string) error {
+	ci := *info
+	ci.Namespace = namespace
+
+	d := NewDeploy(nil)
+	d.Cluster = &ci
+	// there is no user to check, it's decided by captain
+	d.AllowUnchecked = true
+	d.HelmRequest = &appv1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: appv1.HelmRequestSpec{
+			ReleaseName: name,
+			Namespace:   namespace,
+		},
+	}
+	return d.Delete()
+}
+
 // if something block the deletion, and we think it can be ignored, we can do a force delete,
 // remove the `uninstalling` release. This can be caused by
 // 1. unable to build resources(TODO: move from main)
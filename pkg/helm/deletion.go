@@ -0,0 +1,185 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const (
+	// DeletionOrphan removes the release records and keeps all the resources
+	DeletionOrphan = "Orphan"
+	// DeletionDelete uninstalls the release, and returns as soon as the delete requests are sent
+	DeletionDelete = "Delete"
+	// DeletionDeleteAndWait uninstalls the release, and waits until all the resources are gone
+	DeletionDeleteAndWait = "DeleteAndWait"
+
+	// ConditionDeleting reports the progress of DeleteAndWait
+	ConditionDeleting appv1.HelmRequestConditionType = "Deleting"
+
+	// defaultDeletionTimeout is how long DeleteAndWait waits for the resources
+	defaultDeletionTimeout = 10 * time.Minute
+
+	// maxListedResources is the max number of remaining resources listed in the condition message
+	maxListedResources = 5
+)
+
+// WaitingForDeletionError means the release is uninstalled, but some of it's resources are not gone yet. The
+// deletion should be checked again later.
+type WaitingForDeletionError struct {
+	Remaining int
+	Total     int
+}
+
+func (e *WaitingForDeletionError) Error() string {
+	return fmt.Sprintf("waiting for %d/%d resources to be deleted", e.Remaining, e.Total)
+}
+
+// IsWaitingForDeletion checks if the error is a WaitingForDeletionError
+func IsWaitingForDeletion(err error) bool {
+	_, ok := errors.Cause(err).(*WaitingForDeletionError)
+	return ok
+}
+
+// GetDeletionPolicy returns the deletion policy of the helmrequest, captain-keep-resources is the same as Orphan
+func GetDeletionPolicy(hr *appv1.HelmRequest) string {
+	if hr.Annotations != nil && hr.Annotations[util.DeletionPolicyAnnotation] != "" {
+		return hr.Annotations[util.DeletionPolicyAnnotation]
+	}
+	if isSwitchEnabled(hr, util.KeepResourcesAnnotation) {
+		return DeletionOrphan
+	}
+	return DeletionDelete
+}
+
+// getDeletionTimeout returns how long DeleteAndWait waits for the resources
+func getDeletionTimeout(hr *appv1.HelmRequest) time.Duration {
+	if hr.Annotations == nil || hr.Annotations[util.DeletionTimeoutAnnotation] == "" {
+		return defaultDeletionTimeout
+	}
+	timeout, err := time.ParseDuration(hr.Annotations[util.DeletionTimeoutAnnotation])
+	if err != nil {
+		return defaultDeletionTimeout
+	}
+	return timeout
+}
+
+// deleteAndWait uninstalls the release but keeps it's history, so the manifest is still available in the
+// following checks. The history is purged when all the resources in the manifest are gone, or timeout.
+func (d *Deploy) deleteAndWait(cfg *action.Configuration) error {
+	name := GetReleaseName(d.HelmRequest)
+
+	last, err := cfg.Releases.Last(name)
+	if err != nil {
+		if errors.Cause(err) == driver.ErrReleaseNotFound {
+			d.Log.Info("release not exist when delete, ignore it", "name", name)
+			return nil
+		}
+		return err
+	}
+
+	if last.Info.Status != release.StatusUninstalled {
+		client := action.NewUninstall(cfg)
+		client.Timeout = 60 * time.Second
+		client.KeepHistory = true
+		if _, err := client.Run(name); err != nil {
+			return err
+		}
+		if last, err = cfg.Releases.Last(name); err != nil {
+			return err
+		}
+	}
+
+	remaining, total := d.remainingResources(cfg, last)
+	timeout := getDeletionTimeout(d.HelmRequest)
+	switch {
+	case len(remaining) == 0:
+		d.recordDeleting(newCondition("ResourcesDeleted",
+			fmt.Sprintf("All the %d resources are deleted", total), ConditionDeleting, v1.ConditionTrue))
+	case time.Since(last.Info.Deleted.Time) > timeout:
+		d.recordDeleting(newCondition("Timeout",
+			fmt.Sprintf("%d/%d resources are not deleted in %s: %s", len(remaining), total, timeout, listResources(remaining)),
+			ConditionDeleting, v1.ConditionFalse))
+	default:
+		d.recordDeleting(newCondition("WaitingForResources",
+			fmt.Sprintf("Waiting for %d/%d resources to be deleted: %s", len(remaining), total, listResources(remaining)),
+			ConditionDeleting, v1.ConditionFalse))
+		return &WaitingForDeletionError{Remaining: len(remaining), Total: total}
+	}
+
+	// uninstall an uninstalled release purges it's history
+	if _, err := action.NewUninstall(cfg).Run(name); err != nil {
+		return errors.Wrap(err, "purge release history")
+	}
+	return nil
+}
+
+// remainingResources returns the resources in the manifest of the release which still exist, and the number of
+// resources should be deleted. Retained resources and the ones with the keep policy are skipped, since they are
+// never deleted.
+func (d *Deploy) remainingResources(cfg *action.Configuration, rel *release.Release) ([]string, int) {
+	var remaining []string
+	total := 0
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		resources, err := cfg.KubeClient.Build(bytes.NewBufferString(manifest), false)
+		if err != nil {
+			// the CRD is deleted, so are the resources
+			if meta.IsNoMatchError(errors.Cause(err)) || strings.Contains(err.Error(), "no matches for kind") {
+				continue
+			}
+			d.Log.Error(err, "build resources of uninstalled release error")
+			continue
+		}
+		for _, info := range resources {
+			if d.retention != nil && d.retention.matches(info) {
+				continue
+			}
+			if err := info.Get(); err != nil {
+				total++
+				if !apierrors.IsNotFound(err) {
+					d.Log.Error(err, "get resource error", "name", info.Name)
+					remaining = append(remaining, fmt.Sprintf("%s/%s", info.Mapping.GroupVersionKind.Kind, info.Name))
+				}
+				continue
+			}
+			if accessor, err := meta.Accessor(info.Object); err == nil &&
+				accessor.GetAnnotations()[kube.ResourcePolicyAnno] == kube.KeepPolicy {
+				continue
+			}
+			total++
+			remaining = append(remaining, fmt.Sprintf("%s/%s", info.Mapping.GroupVersionKind.Kind, info.Name))
+		}
+	}
+	return remaining, total
+}
+
+// listResources formats the first few resources
+func listResources(resources []string) string {
+	if len(resources) <= maxListedResources {
+		return strings.Join(resources, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(resources[:maxListedResources], ", "), len(resources)-maxListedResources)
+}
+
+// recordDeleting adds the deleting condition, errors are only logged
+func (d *Deploy) recordDeleting(cond *appv1.HelmRequestCondition) {
+	if d.Client == nil {
+		return
+	}
+	if err := d.addCondition(cond); err != nil {
+		d.Log.Error(err, "add deleting condition error")
+	}
+}
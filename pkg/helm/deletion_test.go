@@ -0,0 +1,94 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestGetDeletionPolicy(t *testing.T) {
+	policyOf := func(annotations map[string]string) string {
+		return GetDeletionPolicy(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}})
+	}
+
+	assert.Equal(t, DeletionDelete, policyOf(nil))
+	assert.Equal(t, DeletionDeleteAndWait, policyOf(map[string]string{util.DeletionPolicyAnnotation: DeletionDeleteAndWait}))
+	// captain-keep-resources is the same as Orphan
+	assert.Equal(t, DeletionOrphan, policyOf(map[string]string{util.KeepResourcesAnnotation: "true"}))
+	assert.Equal(t, DeletionDelete, policyOf(map[string]string{util.KeepResourcesAnnotation: "false"}))
+	// the deletion policy wins
+	assert.Equal(t, DeletionDeleteAndWait, policyOf(map[string]string{
+		util.DeletionPolicyAnnotation: DeletionDeleteAndWait,
+		util.KeepResourcesAnnotation:  "true",
+	}))
+}
+
+func TestGetDeletionTimeout(t *testing.T) {
+	timeoutOf := func(value string) time.Duration {
+		return getDeletionTimeout(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{util.DeletionTimeoutAnnotation: value},
+		}})
+	}
+
+	assert.Equal(t, defaultDeletionTimeout, getDeletionTimeout(&appv1.HelmRequest{}))
+	assert.Equal(t, defaultDeletionTimeout, timeoutOf(""))
+	assert.Equal(t, 30*time.Minute, timeoutOf("30m"))
+	// invalid ones fall back to the default
+	assert.Equal(t, defaultDeletionTimeout, timeoutOf("10"))
+	assert.Equal(t, defaultDeletionTimeout, timeoutOf("forever"))
+}
+
+func TestListResources(t *testing.T) {
+	assert.Equal(t, "", listResources(nil))
+	assert.Equal(t, "Pod/a, Pod/b", listResources([]string{"Pod/a", "Pod/b"}))
+
+	var resources []string
+	for i := 0; i < maxListedResources+3; i++ {
+		resources = append(resources, fmt.Sprintf("Pod/p%d", i))
+	}
+	assert.Equal(t, "Pod/p0, Pod/p1, Pod/p2, Pod/p3, Pod/p4 and 3 more", listResources(resources))
+	assert.Equal(t, "Pod/p0, Pod/p1, Pod/p2, Pod/p3, Pod/p4", listResources(resources[:maxListedResources]))
+}
+
+func TestRemainingResources(t *testing.T) {
+	cms := newFakeConfigMaps()
+	client := &buildKubeClient{
+		resources: map[string]kube.ResourceList{
+			"gone":  {cms.info("gone", nil, nil, false)},
+			"alive": {cms.info("alive", nil, nil, true)},
+			// the ones kept by helm and retained by captain are never deleted
+			"kept":     {cms.info("kept", nil, map[string]string{kube.ResourcePolicyAnno: kube.KeepPolicy}, true)},
+			"retained": {cms.info("retained", map[string]string{"app": "db"}, nil, true)},
+		},
+		errors: map[string]error{
+			"crd": errors.New(`no matches for kind "Database" in version "example.com/v1"`),
+		},
+	}
+	rel := &release.Release{Manifest: "gone\n---\nalive\n---\nkept\n---\nretained\n---\ncrd"}
+
+	d := &Deploy{Log: ctrl.Log}
+	remaining, total := d.remainingResources(&action.Configuration{KubeClient: client}, rel)
+	sort.Strings(remaining)
+	assert.Equal(t, []string{"ConfigMap/alive", "ConfigMap/retained"}, remaining)
+	assert.Equal(t, 3, total)
+
+	r, err := getRetention(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{util.RetainSelectorAnnotation: "app=db"},
+	}})
+	assert.Nil(t, err)
+	d.retention = r
+	remaining, total = d.remainingResources(&action.Configuration{KubeClient: client}, rel)
+	assert.Equal(t, []string{"ConfigMap/alive"}, remaining)
+	assert.Equal(t, 2, total)
+}
@@ -88,7 +88,7 @@ func (d *Downloader) downloadChart(name string, version string) (*chart.Chart, e
 
 	repoName, chart := getRepoAndChart(name)
 	if repoName == "" && chart == "" {
-		return nil, errors.New("cannot parse chart name")
+		return nil, NewPermanentError(errors.New("cannot parse chart name"))
 	}
 	log.Info("get chart", "name", name, "version", version)
 
@@ -111,6 +111,10 @@ func (d *Downloader) downloadChart(name string, version string) (*chart.Chart, e
 	cv, err := chartrepo.GetChart(chartResourceName, version, d.ns, d.incfg)
 	if err != nil {
 		log.Error(err, "get chart error")
+		var notFound *chartrepo.VersionNotFoundError
+		if apierrors.IsNotFound(err) || errors.As(err, &notFound) {
+			return nil, NewPermanentError(err)
+		}
 		return nil, err
 	}
 
@@ -215,7 +219,7 @@ func (d *Downloader) downloadChartFromHTTP(hr *appv1.HelmRequest) (*chart.Chart,
 		err = errors.New("helmrequest spec source invalid, require HTTP type")
 	}
 
-	return nil, err
+	return nil, NewPermanentError(err)
 }
 
 func (d *Downloader) pullOCIChart(hr *appv1.HelmRequest) (*chart.Chart, error) {
@@ -0,0 +1,33 @@
+package helm
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// PermanentError is a sync error which can only be fixed by changing the helmrequest or the chart, retrying
+// does not help
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// NewPermanentError marks the error as permanent
+func NewPermanentError(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanentError checks if the sync error can not be fixed by retrying. Besides the ones marked by
+// NewPermanentError, the resources rejected by the validation of the apiserver are also permanent.
+func IsPermanentError(err error) bool {
+	var e *PermanentError
+	return errors.As(err, &e) || apierrors.IsInvalid(err)
+}
@@ -0,0 +1,26 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alauda/captain/pkg/chartrepo"
+	"github.com/gsamokovarov/assert"
+	pkgerrors "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsPermanentError(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Group: "app.alauda.io", Resource: "charts"}, "nginx.stable")
+	assert.True(t, IsPermanentError(NewPermanentError(notFound)))
+	assert.True(t, IsPermanentError(fmt.Errorf("sync error: %w", NewPermanentError(&chartrepo.VersionNotFoundError{}))))
+	invalid := apierrors.NewInvalid(schema.GroupKind{Kind: "Deployment"}, "nginx", nil)
+	assert.True(t, IsPermanentError(pkgerrors.Wrap(invalid, "UPGRADE FAILED")))
+
+	// not marked, eg: the Chart may be created when the ChartRepo is synced
+	assert.False(t, IsPermanentError(notFound))
+	assert.False(t, IsPermanentError(errors.New(`values don't meet the specifications of the schema(s)`)))
+	assert.False(t, IsPermanentError(apierrors.NewServiceUnavailable("unavailable")))
+}
@@ -0,0 +1,117 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// fakeConfigMaps serves the ConfigMaps in the default namespace for resource.Info, the patches are recorded
+type fakeConfigMaps struct {
+	existing map[string]*corev1.ConfigMap
+	patched  map[string]string
+}
+
+func newFakeConfigMaps() *fakeConfigMaps {
+	return &fakeConfigMaps{existing: map[string]*corev1.ConfigMap{}, patched: map[string]string{}}
+}
+
+// info returns the resource of the ConfigMap in the manifest, it exists in the cluster if created
+func (f *fakeConfigMaps) info(name string, labels, annotations map[string]string, created bool) *resource.Info {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+	if created {
+		f.existing[name] = cm
+	}
+	return &resource.Info{
+		Client: &fakerest.RESTClient{
+			NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+			GroupVersion:         corev1.SchemeGroupVersion,
+			Client:               fakerest.CreateHTTPClient(f.roundTrip),
+		},
+		Mapping: &meta.RESTMapping{
+			Resource:         corev1.SchemeGroupVersion.WithResource("configmaps"),
+			GroupVersionKind: corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+			Scope:            meta.RESTScopeNamespace,
+		},
+		Namespace: "default",
+		Name:      name,
+		Object:    cm.DeepCopy(),
+	}
+}
+
+func (f *fakeConfigMaps) roundTrip(req *http.Request) (*http.Response, error) {
+	name := path.Base(req.URL.Path)
+	cm, ok := f.existing[name]
+	if !ok {
+		return f.respond(http.StatusNotFound, &metav1.Status{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+			Status:   metav1.StatusFailure,
+			Reason:   metav1.StatusReasonNotFound,
+			Code:     http.StatusNotFound,
+		})
+	}
+	if req.Method == http.MethodPatch {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		f.patched[name] = string(data)
+	}
+	return f.respond(http.StatusOK, cm)
+}
+
+func (f *fakeConfigMaps) respond(code int, obj interface{}) (*http.Response, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	return &http.Response{StatusCode: code, Header: header, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+// buildKubeClient builds the resources by the content of the manifests
+type buildKubeClient struct {
+	kube.Interface
+	resources map[string]kube.ResourceList
+	errors    map[string]error
+	// deleted are the resources deleted
+	deleted kube.ResourceList
+}
+
+func (c *buildKubeClient) Build(reader io.Reader, validate bool) (kube.ResourceList, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSpace(string(data))
+	if err, ok := c.errors[content]; ok {
+		return nil, err
+	}
+	return c.resources[content], nil
+}
+
+func (c *buildKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	c.deleted = append(c.deleted, resources...)
+	return &kube.Result{Deleted: resources}, nil
+}
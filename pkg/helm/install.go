@@ -64,7 +64,7 @@ func (d *Deploy) install(chart *chart.Chart) (*release.Release, error) {
 	validInstallableChart, err := isChartInstallable(chart)
 	if !validInstallableChart {
 		log.Error(err, "not installable error")
-		return nil, err
+		return nil, NewPermanentError(err)
 	}
 	d.HelmRequest.Status.Version = chart.Metadata.Version
 
@@ -0,0 +1,281 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	// OutputsSuffix is the suffix of the ConfigMap/Secret name of the outputs, <helmrequest>-outputs
+	OutputsSuffix = "-outputs"
+	// OutputsOfLabel is the label of the outputs ConfigMap/Secret, the value is the name of the helmrequest
+	OutputsOfLabel = "captain.cpaas.io/outputs-of"
+	// outputsValuesKey is the key contains all the outputs as values, it's the default key of valuesFrom
+	outputsValuesKey = "values.yaml"
+)
+
+// Output extracts a field from a resource of the release, or the computed values of the release
+type Output struct {
+	// Name is the key in the generated ConfigMap/Secret. In values.yaml, dots mean nested keys
+	Name string `json:"name"`
+	// Resource is the resource in the release to read from, <Kind>/<name> or <apiVersion>/<Kind>/<name>. The
+	// live object is read. If it's empty, the computed values of the release are read.
+	Resource string `json:"resource,omitempty"`
+	// JSONPath is the field to extract, eg: {.spec.clusterIP}
+	JSONPath string `json:"jsonPath"`
+	// Secret stores the output in the Secret instead of the ConfigMap
+	Secret bool `json:"secret,omitempty"`
+}
+
+// OutputsName returns the name of the ConfigMap/Secret contains the outputs of the helmrequest
+func OutputsName(hr *appv1.HelmRequest) string {
+	return hr.GetName() + OutputsSuffix
+}
+
+// getOutputs parses the outputs of the helmrequest from the annotation, it's a yaml/json list of Output
+func getOutputs(hr *appv1.HelmRequest) ([]Output, error) {
+	if hr.Annotations == nil || hr.Annotations[util.OutputsAnnotation] == "" {
+		return nil, nil
+	}
+	var outputs []Output
+	if err := yaml.Unmarshal([]byte(hr.Annotations[util.OutputsAnnotation]), &outputs); err != nil {
+		return nil, fmt.Errorf("parse annotation %s error: %s", util.OutputsAnnotation, err.Error())
+	}
+	for _, item := range outputs {
+		if item.Name == "" || item.JSONPath == "" {
+			return nil, fmt.Errorf("name and jsonPath are required in annotation %s", util.OutputsAnnotation)
+		}
+	}
+	return outputs, nil
+}
+
+// WriteOutputs extracts the outputs from the synced release, and writes them to the ConfigMap/Secret named
+// <helmrequest>-outputs in the namespace of the helmrequest, so dependents can read them by valuesFrom.
+func (d *Deploy) WriteOutputs(rel *release.Release) error {
+	outputs, err := getOutputs(d.HelmRequest)
+	if err != nil || len(outputs) == 0 {
+		return err
+	}
+
+	data := make(map[string]string)
+	secretData := make(map[string]string)
+	values := Values{}
+	secretValues := Values{}
+	for _, item := range outputs {
+		value, err := d.extractOutput(rel, item)
+		if err != nil {
+			return fmt.Errorf("extract output %s error: %s", item.Name, err.Error())
+		}
+		if item.Secret {
+			secretData[item.Name] = value
+			setNestedValue(secretValues, item.Name, value)
+		} else {
+			data[item.Name] = value
+			setNestedValue(values, item.Name, value)
+		}
+	}
+
+	client, err := kubernetes.NewForConfig(d.InCluster.ToRestConfig())
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := d.applyOutputs(client, false, data, values); err != nil {
+			return err
+		}
+	}
+	if len(secretData) > 0 {
+		if err := d.applyOutputs(client, true, secretData, secretValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractOutput reads the field from the live resource or the computed values
+func (d *Deploy) extractOutput(rel *release.Release, item Output) (string, error) {
+	var obj interface{}
+	if item.Resource == "" {
+		values, err := chartutil.CoalesceValues(rel.Chart, rel.Config)
+		if err != nil {
+			return "", err
+		}
+		obj = map[string]interface{}(values)
+	} else {
+		live, err := d.getReleaseResource(rel, item.Resource)
+		if err != nil {
+			return "", err
+		}
+		obj = live
+	}
+
+	j := jsonpath.New(item.Name)
+	if err := j.Parse(item.JSONPath); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := j.Execute(&buf, obj); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// getReleaseResource finds the resource in the manifest of the release, and returns the live object
+func (d *Deploy) getReleaseResource(rel *release.Release, resource string) (map[string]interface{}, error) {
+	parts := strings.Split(resource, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid resource %s, should be <Kind>/<name> or <apiVersion>/<Kind>/<name>", resource)
+	}
+	apiVersion := strings.Join(parts[:len(parts)-2], "/")
+	kind, name := parts[len(parts)-2], parts[len(parts)-1]
+
+	cfg, err := d.newActionConfig()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := cfg.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range resources {
+		gvk := info.Mapping.GroupVersionKind
+		if gvk.Kind != kind || info.Name != name || (apiVersion != "" && gvk.GroupVersion().String() != apiVersion) {
+			continue
+		}
+		if err := info.Get(); err != nil {
+			return nil, err
+		}
+		return runtime.DefaultUnstructuredConverter.ToUnstructured(info.Object)
+	}
+	return nil, fmt.Errorf("resource %s not found in release %s", resource, rel.Name)
+}
+
+// setNestedValue sets the value in values by the dotted key
+func setNestedValue(values Values, key string, value string) {
+	keys := strings.Split(key, ".")
+	current := values
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := current[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[k] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+// applyOutputs creates or updates the outputs ConfigMap/Secret
+func (d *Deploy) applyOutputs(client kubernetes.Interface, secret bool, data map[string]string, values Values) error {
+	hr := d.HelmRequest
+	encoded, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	data[outputsValuesKey] = string(encoded)
+
+	meta := metav1.ObjectMeta{
+		Name:      OutputsName(hr),
+		Namespace: hr.GetNamespace(),
+		Labels:    map[string]string{OutputsOfLabel: hr.GetName()},
+	}
+	// the outputs are deleted with the helmrequest if they live in the same cluster
+	if hr.ClusterName == "" && hr.UID != "" {
+		meta.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(hr, appv1.SchemeGroupVersion.WithKind("HelmRequest")),
+		}
+	}
+
+	// skip the write if nothing changed, it's called in every sync
+	ctx := context.Background()
+	if secret {
+		encodedData := make(map[string][]byte, len(data))
+		for k, v := range data {
+			encodedData[k] = []byte(v)
+		}
+		current, err := client.CoreV1().Secrets(meta.Namespace).Get(ctx, meta.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.CoreV1().Secrets(meta.Namespace).Create(ctx, &v1.Secret{ObjectMeta: meta, Data: encodedData}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(current.Data, encodedData) && isOutputsMetaEqual(current.ObjectMeta, meta) {
+			return nil
+		}
+		current.Labels, current.OwnerReferences, current.Data = meta.Labels, meta.OwnerReferences, encodedData
+		_, err = client.CoreV1().Secrets(meta.Namespace).Update(ctx, current, metav1.UpdateOptions{})
+		return err
+	}
+
+	current, err := client.CoreV1().ConfigMaps(meta.Namespace).Get(ctx, meta.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.CoreV1().ConfigMaps(meta.Namespace).Create(ctx, &v1.ConfigMap{ObjectMeta: meta, Data: data}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(current.Data, data) && isOutputsMetaEqual(current.ObjectMeta, meta) {
+		return nil
+	}
+	current.Labels, current.OwnerReferences, current.Data = meta.Labels, meta.OwnerReferences, data
+	_, err = client.CoreV1().ConfigMaps(meta.Namespace).Update(ctx, current, metav1.UpdateOptions{})
+	return err
+}
+
+// isOutputsMetaEqual checks if the labels and owners of the outputs are up to date
+func isOutputsMetaEqual(current, desired metav1.ObjectMeta) bool {
+	return equality.Semantic.DeepEqual(current.Labels, desired.Labels) &&
+		equality.Semantic.DeepEqual(current.OwnerReferences, desired.OwnerReferences)
+}
+
+// DeleteOutputs deletes the outputs ConfigMap/Secret of the helmrequest which are not owned by it, they are
+// written for the helmrequests in other clusters and not garbage collected. It's called before the finalizer
+// is removed.
+func DeleteOutputs(client kubernetes.Interface, hr *appv1.HelmRequest) error {
+	ctx := context.Background()
+	name, ns := OutputsName(hr), hr.GetNamespace()
+
+	cm, err := client.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isOrphanOutputs(cm.ObjectMeta, hr) {
+		if err := client.CoreV1().ConfigMaps(ns).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	secret, err := client.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isOrphanOutputs(secret.ObjectMeta, hr) {
+		if err := client.CoreV1().Secrets(ns).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isOrphanOutputs checks if the object is the outputs of the helmrequest written by captain, and has no owner
+func isOrphanOutputs(meta metav1.ObjectMeta, hr *appv1.HelmRequest) bool {
+	return meta.Labels[OutputsOfLabel] == hr.GetName() && len(meta.OwnerReferences) == 0
+}
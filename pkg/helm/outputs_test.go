@@ -0,0 +1,49 @@
+package helm
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApplyOutputs(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	hr := &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}}
+	hr.ClusterName = "business"
+	d := &Deploy{HelmRequest: hr}
+
+	write := func(host string) {
+		assert.Nil(t, d.applyOutputs(client, false, map[string]string{"host": host}, Values{"host": host}))
+		assert.Nil(t, d.applyOutputs(client, true, map[string]string{"password": "secret"}, Values{"password": "secret"}))
+	}
+	countUpdates := func() int {
+		n := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "update" {
+				n++
+			}
+		}
+		return n
+	}
+
+	write("db.default")
+	write("db.default")
+	assert.Equal(t, 0, countUpdates())
+
+	write("db.other")
+	assert.Equal(t, 1, countUpdates())
+	cm, err := client.CoreV1().ConfigMaps("default").Get(context.Background(), "db-outputs", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "db.other", cm.Data["host"])
+
+	// the outputs of a helmrequest in another cluster are not owned, delete them with the helmrequest
+	assert.Nil(t, DeleteOutputs(client, hr))
+	_, err = client.CoreV1().ConfigMaps("default").Get(context.Background(), "db-outputs", metav1.GetOptions{})
+	assert.NotNil(t, err)
+	_, err = client.CoreV1().Secrets("default").Get(context.Background(), "db-outputs", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
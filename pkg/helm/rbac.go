@@ -0,0 +1,261 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/thoas/go-funk"
+	"helm.sh/helm/v3/pkg/kube"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// ForbiddenError contains all the resources the user is not allowed to operate
+type ForbiddenError struct {
+	User   string
+	Denied []string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("user %s is not allowed to: %s", e.User, strings.Join(e.Denied, "; "))
+}
+
+// newRbacClients create the RbacClients for the users of the HelmRequest: the creator, and the last modifier if
+// it's another user. The resources are applied only if all of them are allowed. System users are not checked.
+// If no user is recorded, the HelmRequest is not allowed to deploy unless allowUnchecked is set.
+func newRbacClients(hr *appv1.HelmRequest, allowUnchecked bool) ([]*RbacClient, error) {
+	var clients []*RbacClient
+	recorded := false
+	for _, keys := range [][2]string{
+		{util.CreatorAnnotation, util.CreatorGroupsAnnotation},
+		{util.ModifierAnnotation, util.ModifierGroupsAnnotation},
+	} {
+		user := hr.Annotations[keys[0]]
+		if user == "" {
+			continue
+		}
+		recorded = true
+		if funk.ContainsString(systemUsers, user) || (len(clients) > 0 && clients[0].user == user) {
+			continue
+		}
+		r := &RbacClient{user: user}
+		if groups := hr.Annotations[keys[1]]; groups != "" {
+			r.groups = strings.Split(groups, ",")
+		}
+		clients = append(clients, r)
+	}
+
+	if !recorded && !allowUnchecked {
+		return nil, NewPermanentError(fmt.Errorf("no creator is recorded for helmrequest %s by the webhook, it's not "+
+			"allowed to deploy unless captain runs with --allow-unchecked-helmrequests", hr.GetName()))
+	}
+	return clients, nil
+}
+
+// check checks if the user is allowed to do the verb on all the resources. All the denied resources are
+// collected in a ForbiddenError.
+func (r *RbacClient) check(verb string, resources kube.ResourceList) error {
+	if len(resources) == 0 {
+		return nil
+	}
+
+	client, err := r.getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	var denied []string
+	for _, info := range resources {
+		allowed, reason, err := r.review(client, verb, info)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			msg := fmt.Sprintf("%s %s", verb, describeResource(info))
+			if reason != "" {
+				msg = fmt.Sprintf("%s (%s)", msg, reason)
+			}
+			denied = append(denied, msg)
+		}
+	}
+
+	if len(denied) > 0 {
+		return &ForbiddenError{User: r.user, Denied: denied}
+	}
+	return nil
+}
+
+// checkUpdate checks permissions for the resources to be created/updated/deleted by an update
+func (r *RbacClient) checkUpdate(original, target kube.ResourceList, force bool) error {
+	updateVerb := "patch"
+	if force {
+		updateVerb = "update"
+	}
+
+	var errs []string
+	var denied *ForbiddenError
+	checks := []struct {
+		verb      string
+		resources kube.ResourceList
+	}{
+		{"create", target.Difference(original)},
+		{updateVerb, target.Intersect(original)},
+		{"delete", original.Difference(target)},
+	}
+	for _, item := range checks {
+		err := r.check(item.verb, item.resources)
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(*ForbiddenError); ok {
+			if denied == nil {
+				denied = &ForbiddenError{User: e.User}
+			}
+			denied.Denied = append(denied.Denied, e.Denied...)
+			continue
+		}
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("check permissions error: %s", strings.Join(errs, "; "))
+	}
+	if denied != nil {
+		return denied
+	}
+	return nil
+}
+
+// getKubeClient returns the client to create SubjectAccessReviews
+func (r *RbacClient) getKubeClient() (kubernetes.Interface, error) {
+	if r.kubeClient != nil {
+		return r.kubeClient, nil
+	}
+	client, err := kubernetes.NewForConfig(r.config)
+	if err != nil {
+		return nil, err
+	}
+	r.kubeClient = client
+	return client, nil
+}
+
+// review send a SubjectAccessReview for one resource
+func (r *RbacClient) review(client kubernetes.Interface, verb string, info *resource.Info) (bool, string, error) {
+	attrs := &authorizationv1.ResourceAttributes{
+		Namespace: info.Namespace,
+		Verb:      verb,
+		Name:      info.Name,
+	}
+	if info.Mapping != nil {
+		attrs.Group = info.Mapping.Resource.Group
+		attrs.Version = info.Mapping.Resource.Version
+		attrs.Resource = info.Mapping.Resource.Resource
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               r.user,
+			Groups:             r.groups,
+		},
+	}
+	result, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	return result.Status.Allowed, result.Status.Reason, nil
+}
+
+// IsHelmRequestAccessAllowed checks if the users of the HelmRequest are all allowed to do the verb on the
+// HelmRequest namespace/name, eg: to depend on or cascade delete a HelmRequest in another namespace. The
+// HelmRequests without a recorded user are allowed, they are created by system users or captain itself.
+func IsHelmRequestAccessAllowed(client kubernetes.Interface, hr *appv1.HelmRequest, verb, namespace, name string) (bool, error) {
+	clients, err := newRbacClients(hr, true)
+	if err != nil {
+		return false, err
+	}
+	info := &resource.Info{
+		Namespace: namespace,
+		Name:      name,
+		Mapping: &meta.RESTMapping{
+			Resource: appv1.SchemeGroupVersion.WithResource("helmrequests"),
+		},
+	}
+	for _, r := range clients {
+		allowed, reason, err := r.review(client, verb, info)
+		if err != nil {
+			return false, err
+		}
+		if !allowed {
+			klog.Infof("user %s is not allowed to %s %s: %s", r.user, verb, describeResource(info), reason)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// describeResource format a resource as <resource>.<group> <namespace>/<name>
+func describeResource(info *resource.Info) string {
+	kind := info.ObjectName()
+	if info.Mapping != nil {
+		kind = info.Mapping.Resource.GroupResource().String()
+	}
+	if info.Namespace == "" {
+		return fmt.Sprintf("%s %s", kind, info.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", kind, info.Namespace, info.Name)
+}
+
+// rbacKubeClient wraps the helm kube client, it checks the permissions of the HelmRequest's users
+// before create/update/delete resources, so a user can only deploy what they are allowed to create.
+// Notes: hooks are created and checked one by one, so a denied hook may be found after some hooks ran.
+type rbacKubeClient struct {
+	kube.Interface
+
+	rbac []*RbacClient
+
+	// skipDelete skips the check of delete when the HelmRequest is being deleted. The users were allowed to
+	// create the resources, and the finalizer should not be blocked after they lose the permissions.
+	skipDelete bool
+}
+
+// Create checks create permission for all the resources before create them
+func (c *rbacKubeClient) Create(resources kube.ResourceList) (*kube.Result, error) {
+	for _, r := range c.rbac {
+		if err := r.check("create", resources); err != nil {
+			return nil, err
+		}
+	}
+	return c.Interface.Create(resources)
+}
+
+// Update checks permissions for the resources to be created/updated/deleted before update them
+func (c *rbacKubeClient) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	for _, r := range c.rbac {
+		if err := r.checkUpdate(original, target, force); err != nil {
+			return nil, err
+		}
+	}
+	return c.Interface.Update(original, target, force)
+}
+
+// Delete checks delete permission for all the resources before delete them
+func (c *rbacKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	if c.skipDelete {
+		return c.Interface.Delete(resources)
+	}
+	for _, r := range c.rbac {
+		if err := r.check("delete", resources); err != nil {
+			klog.Warningf("check delete permissions for user %s error: %s", r.user, err.Error())
+			return nil, []error{err}
+		}
+	}
+	return c.Interface.Delete(resources)
+}
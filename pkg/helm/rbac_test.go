@@ -0,0 +1,169 @@
+package helm
+
+import (
+	"testing"
+
+	bundlev1alpha1 "github.com/alauda/captain/api/v1alpha1"
+	"github.com/alauda/captain/pkg/generator"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/kube"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGeneratedHelmRequestReview(t *testing.T) {
+	// the template tries to deploy as an admin
+	g := &bundlev1alpha1.HelmRequestGenerator{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "tenants",
+			Namespace:   "t1",
+			Annotations: map[string]string{util.CreatorAnnotation: "alice", util.CreatorGroupsAnnotation: "tenants"},
+		},
+		Spec: bundlev1alpha1.HelmRequestGeneratorSpec{
+			Template: bundlev1alpha1.HelmRequestTemplate{
+				Metadata: bundlev1alpha1.HelmRequestTemplateMeta{
+					Name:        "{{name}}",
+					Annotations: map[string]string{util.CreatorAnnotation: "kubernetes-admin"},
+				},
+				Spec: appv1.HelmRequestSpec{Chart: "stable/operator"},
+			},
+		},
+	}
+	hrs, err := generator.Render(g, []generator.Params{{"name": "operator"}})
+	assert.Nil(t, err)
+	assert.Len(t, 1, hrs)
+
+	clients, err := newRbacClients(hrs[0], false)
+	assert.Nil(t, err)
+	assert.Len(t, 1, clients)
+	rbac := clients[0]
+	assert.Equal(t, "alice", rbac.user)
+
+	// alice is only allowed to operate in the t1 namespace
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.User == "alice" && sar.Spec.ResourceAttributes.Namespace == "t1"
+		return true, sar, nil
+	})
+	info := &resource.Info{
+		Name: "operator",
+		Mapping: &meta.RESTMapping{
+			Resource: schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+		},
+	}
+	allowed, _, err := rbac.review(client, "create", info)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	info.Namespace = "t1"
+	allowed, _, err = rbac.review(client, "create", info)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+}
+
+func TestNewRbacClients(t *testing.T) {
+	newHelmRequest := func(annotations map[string]string) *appv1.HelmRequest {
+		return &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations}}
+	}
+	usersOf := func(clients []*RbacClient) []string {
+		var users []string
+		for _, r := range clients {
+			users = append(users, r.user)
+		}
+		return users
+	}
+
+	// no creator recorded, fail closed
+	_, err := newRbacClients(newHelmRequest(nil), false)
+	assert.NotNil(t, err)
+	assert.True(t, IsPermanentError(err))
+	clients, err := newRbacClients(newHelmRequest(nil), true)
+	assert.Nil(t, err)
+	assert.Len(t, 0, clients)
+
+	clients, err = newRbacClients(newHelmRequest(map[string]string{
+		util.CreatorAnnotation:       "alice",
+		util.CreatorGroupsAnnotation: "tenants,dev",
+		util.ModifierAnnotation:      "alice",
+	}), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice"}, usersOf(clients))
+	assert.Equal(t, []string{"tenants", "dev"}, clients[0].groups)
+
+	// both the creator and the modifier are checked
+	clients, err = newRbacClients(newHelmRequest(map[string]string{
+		util.CreatorAnnotation:  "alice",
+		util.ModifierAnnotation: "bob",
+	}), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, usersOf(clients))
+
+	// system users are not checked
+	clients, err = newRbacClients(newHelmRequest(map[string]string{
+		util.CreatorAnnotation:  "kubernetes-admin",
+		util.ModifierAnnotation: "bob",
+	}), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bob"}, usersOf(clients))
+	clients, err = newRbacClients(newHelmRequest(map[string]string{util.CreatorAnnotation: "admin"}), false)
+	assert.Nil(t, err)
+	assert.Len(t, 0, clients)
+}
+
+// recordKubeClient records the resources deleted
+type recordKubeClient struct {
+	kube.Interface
+	deleted kube.ResourceList
+}
+
+func (c *recordKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	c.deleted = append(c.deleted, resources...)
+	return &kube.Result{Deleted: resources}, nil
+}
+
+func TestRbacKubeClient(t *testing.T) {
+	// only alice is allowed to operate
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.User == "alice"
+		return true, sar, nil
+	})
+	resources := kube.ResourceList{{
+		Name:      "app",
+		Namespace: "t1",
+		Mapping: &meta.RESTMapping{
+			Resource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+	}}
+	underlying := &recordKubeClient{}
+	c := &rbacKubeClient{
+		Interface: underlying,
+		rbac:      []*RbacClient{{user: "alice", kubeClient: client}, {user: "bob", kubeClient: client}},
+	}
+
+	// bob updated the HelmRequest created by alice, the permissions of both are checked
+	_, err := c.Create(resources)
+	assert.NotNil(t, err)
+	denied, ok := err.(*ForbiddenError)
+	assert.True(t, ok)
+	assert.Equal(t, "bob", denied.User)
+	_, errs := c.Delete(resources)
+	assert.Len(t, 1, errs)
+	assert.Len(t, 0, underlying.deleted)
+
+	// the uninstall on deletion is not checked
+	c.skipDelete = true
+	_, errs = c.Delete(resources)
+	assert.Len(t, 0, errs)
+	assert.Len(t, 1, underlying.deleted)
+}
@@ -0,0 +1,57 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/alauda/captain/pkg/cluster"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"helm.sh/helm/v3/pkg/kube"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// NotReadyResources checks the resources of the deployed release of the helmrequest in the cluster, and returns
+// the ones not ready yet, in the format of Kind/name. Jobs are only checked if checkJobs is true.
+func NotReadyResources(ctx context.Context, info *cluster.Info, hr *appv1.HelmRequest, checkJobs bool) ([]string, error) {
+	ci := *info
+	ci.Namespace = hr.GetReleaseNamespace()
+
+	d := NewDeploy(nil)
+	d.Cluster = &ci
+	d.HelmRequest = hr
+	// the resources are only read
+	d.AllowUnchecked = true
+	cfg, err := d.newActionConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := cfg.Releases.Deployed(GetReleaseName(hr))
+	if err != nil {
+		return nil, err
+	}
+	resources, err := cfg.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(ci.ToRestConfig())
+	if err != nil {
+		return nil, err
+	}
+	checker := kube.NewReadyChecker(client, klog.Infof, kube.PausedAsReady(true), kube.CheckJobs(checkJobs))
+
+	var result []string
+	for _, item := range resources {
+		ready, err := checker.IsReady(ctx, item)
+		if err != nil {
+			klog.Warningf("check readiness of %s/%s error: %s", item.Mapping.GroupVersionKind.Kind, item.Name, err.Error())
+		}
+		if !ready {
+			result = append(result, fmt.Sprintf("%s/%s", item.Mapping.GroupVersionKind.Kind, item.Name))
+		}
+	}
+	return result, nil
+}
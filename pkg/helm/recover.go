@@ -0,0 +1,165 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// RecoverForward marks the interrupted version as failed, the next upgrade continues from it
+	RecoverForward = "forward"
+	// RecoverRollback rolls back to the last deployed version before the next upgrade
+	RecoverRollback = "rollback"
+	// RecoverFail does nothing and fails the sync, users should recover it manually
+	RecoverFail = "fail"
+
+	// ConditionRecovered records how captain recovered an interrupted upgrade/rollback
+	ConditionRecovered appv1.HelmRequestConditionType = "Recovered"
+
+	// recoveredDescription is in the description of the interrupted versions marked as failed by captain
+	recoveredDescription = "marked as failed by captain"
+)
+
+// interruption describes an interrupted upgrade/rollback
+type interruption struct {
+	// last is the interrupted version
+	last *release.Release
+	// deployed is the last deployed version, may be nil
+	deployed *release.Release
+
+	// created/total is the number of resources only in the interrupted version, which are created
+	created int
+	total   int
+}
+
+func (i *interruption) String() string {
+	operation := "upgrade"
+	if i.last.Info.Status == release.StatusPendingRollback {
+		operation = "rollback"
+	}
+	msg := fmt.Sprintf("%s to version %d was interrupted, %d/%d new resources were created", operation, i.last.Version, i.created, i.total)
+	if i.deployed != nil {
+		msg = fmt.Sprintf("%s, last deployed version is %d", msg, i.deployed.Version)
+	}
+	return msg
+}
+
+// getRecoverPolicy returns the policy to recover a release from pending-upgrade/pending-rollback
+func getRecoverPolicy(hr *appv1.HelmRequest) string {
+	if hr.Annotations != nil && hr.Annotations[util.RecoverPolicyAnnotation] != "" {
+		return hr.Annotations[util.RecoverPolicyAnnotation]
+	}
+	return RecoverForward
+}
+
+// recoverPending checks if the last upgrade/rollback of the release was interrupted (eg: captain crashed), which
+// leaves the release in pending-upgrade/pending-rollback and blocks all the following upgrades. It recovers the
+// release according to the policy, and records the decision as a condition.
+func (d *Deploy) recoverPending(cfg *action.Configuration) error {
+	name := GetReleaseName(d.HelmRequest)
+	last, err := cfg.Releases.Last(name)
+	if err != nil {
+		if errors.Cause(err) == driver.ErrReleaseNotFound {
+			return nil
+		}
+		return err
+	}
+	if last.Info.Status != release.StatusPendingUpgrade && last.Info.Status != release.StatusPendingRollback {
+		return nil
+	}
+
+	i := &interruption{last: last}
+	if deployed, err := cfg.Releases.Deployed(name); err == nil {
+		i.deployed = deployed
+	}
+	d.countCreated(cfg, i)
+
+	policy := getRecoverPolicy(d.HelmRequest)
+	if policy == RecoverRollback && i.deployed == nil {
+		policy = RecoverFail
+	}
+	d.Log.Info("found interrupted release", "name", name, "detail", i.String(), "policy", policy)
+
+	switch policy {
+	case RecoverForward:
+		last.SetStatus(release.StatusFailed, fmt.Sprintf("%s, %s", i, recoveredDescription))
+		if err := cfg.Releases.Update(last); err != nil {
+			return err
+		}
+		d.recordRecovered(newCondition("RolledForward",
+			fmt.Sprintf("%s, marked it as failed and continue to upgrade", i), ConditionRecovered, v1.ConditionTrue))
+		return nil
+	case RecoverRollback:
+		last.SetStatus(release.StatusFailed, fmt.Sprintf("%s, %s to roll back", i, recoveredDescription))
+		if err := cfg.Releases.Update(last); err != nil {
+			return err
+		}
+		client := action.NewRollback(cfg)
+		client.Version = i.deployed.Version
+		client.Timeout = 180 * time.Second
+		if err := client.Run(name); err != nil {
+			return errors.Wrapf(err, "rollback to version %d failed", i.deployed.Version)
+		}
+		d.recordRecovered(newCondition("RolledBack",
+			fmt.Sprintf("%s, rolled back to version %d", i, i.deployed.Version), ConditionRecovered, v1.ConditionTrue))
+		return nil
+	default:
+		msg := fmt.Sprintf("%s. Fix it manually, or set annotation %s to %s or %s to let captain recover it",
+			i, util.RecoverPolicyAnnotation, RecoverForward, RecoverRollback)
+		d.recordRecovered(newCondition("ManualRecoveryRequired", msg, ConditionRecovered, v1.ConditionFalse))
+		return errors.New(msg)
+	}
+}
+
+// IsRecovered checks if the failed version is an interrupted one marked by captain, it's kept in the history to
+// tell what happened, instead of being cleaned up as an unfinished version
+func IsRecovered(info *release.Info) bool {
+	return info != nil && info.Status == release.StatusFailed && strings.Contains(info.Description, recoveredDescription)
+}
+
+// recordRecovered adds the recovered condition, the release is already recovered, so errors are only logged
+func (d *Deploy) recordRecovered(cond *appv1.HelmRequestCondition) {
+	if err := d.addCondition(cond); err != nil {
+		d.Log.Error(err, "add recovered condition error")
+	}
+}
+
+// countCreated finds out how many resources only exist in the interrupted version have been created,
+// which tells if the interrupted operation has applied resources partially.
+func (d *Deploy) countCreated(cfg *action.Configuration, i *interruption) {
+	target, err := cfg.KubeClient.Build(bytes.NewBufferString(i.last.Manifest), false)
+	if err != nil {
+		d.Log.Error(err, "build resources of interrupted release error")
+		return
+	}
+	if i.deployed != nil {
+		original, err := cfg.KubeClient.Build(bytes.NewBufferString(i.deployed.Manifest), false)
+		if err != nil {
+			d.Log.Error(err, "build resources of deployed release error")
+			return
+		}
+		target = target.Difference(original)
+	}
+
+	i.total = len(target)
+	for _, info := range target {
+		if err := info.Get(); err != nil {
+			if !apierrors.IsNotFound(err) {
+				d.Log.Error(err, "get resource error", "name", info.Name)
+			}
+			continue
+		}
+		i.created++
+	}
+}
@@ -0,0 +1,148 @@
+package helm
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestInterruptionString(t *testing.T) {
+	newRelease := func(version int, status release.Status) *release.Release {
+		return &release.Release{Version: version, Info: &release.Info{Status: status}}
+	}
+
+	i := &interruption{last: newRelease(3, release.StatusPendingUpgrade), deployed: newRelease(2, release.StatusDeployed), created: 1, total: 2}
+	assert.Equal(t, "upgrade to version 3 was interrupted, 1/2 new resources were created, last deployed version is 2", i.String())
+
+	i = &interruption{last: newRelease(1, release.StatusPendingRollback)}
+	assert.Equal(t, "rollback to version 1 was interrupted, 0/0 new resources were created", i.String())
+}
+
+func TestRecoverPending(t *testing.T) {
+	newRelease := func(version int, status release.Status, manifest string) *release.Release {
+		return &release.Release{
+			Name:      "test",
+			Namespace: "default",
+			Version:   version,
+			Info:      &release.Info{Status: status},
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "test", Version: "0.1.0"}},
+			Manifest:  manifest,
+		}
+	}
+	// newDeploy returns a deploy of the helmrequest with the policy, the releases are stored in memory
+	newDeploy := func(policy string, kubeClient kube.Interface, releases ...*release.Release) (*Deploy, *action.Configuration) {
+		hr := &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "test",
+			Annotations: map[string]string{util.RecoverPolicyAnnotation: policy},
+		}}
+		cfg := &action.Configuration{
+			KubeClient: kubeClient,
+			Releases:   storage.Init(driver.NewMemory()),
+			Log:        klog.Infof,
+		}
+		for _, rel := range releases {
+			assert.Nil(t, cfg.Releases.Create(rel))
+		}
+		return &Deploy{HelmRequest: hr, Client: fake.NewSimpleClientset(hr), Log: ctrl.Log}, cfg
+	}
+	recovered := func(d *Deploy) appv1.HelmRequestCondition {
+		hr, err := d.Client.AppV1().HelmRequests("default").Get("test", metav1.GetOptions{})
+		assert.Nil(t, err)
+		for _, cond := range hr.Status.Conditions {
+			if cond.Type == ConditionRecovered {
+				return cond
+			}
+		}
+		return appv1.HelmRequestCondition{}
+	}
+	printing := &kubefake.PrintingKubeClient{Out: ioutil.Discard}
+
+	t.Run("forward", func(t *testing.T) {
+		// b is created by the interrupted upgrade, c is not
+		cms := newFakeConfigMaps()
+		a := cms.info("a", nil, nil, true)
+		kubeClient := &buildKubeClient{resources: map[string]kube.ResourceList{
+			"v1": {a},
+			"v2": {a, cms.info("b", nil, nil, true), cms.info("c", nil, nil, false)},
+		}}
+		d, cfg := newDeploy("", kubeClient,
+			newRelease(1, release.StatusDeployed, "v1"), newRelease(2, release.StatusPendingUpgrade, "v2"))
+		assert.Nil(t, d.recoverPending(cfg))
+
+		last, err := cfg.Releases.Last("test")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, last.Version)
+		assert.Equal(t, release.StatusFailed, last.Info.Status)
+		assert.True(t, IsRecovered(last.Info))
+		cond := recovered(d)
+		assert.Equal(t, "RolledForward", cond.Reason)
+		assert.True(t, strings.Contains(cond.Message, "1/2 new resources were created"))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		d, cfg := newDeploy(RecoverRollback, printing,
+			newRelease(1, release.StatusDeployed, ""), newRelease(2, release.StatusPendingUpgrade, ""))
+		assert.Nil(t, d.recoverPending(cfg))
+
+		interrupted, err := cfg.Releases.Get("test", 2)
+		assert.Nil(t, err)
+		assert.True(t, IsRecovered(interrupted.Info))
+		last, err := cfg.Releases.Last("test")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, last.Version)
+		assert.Equal(t, release.StatusDeployed, last.Info.Status)
+		assert.Equal(t, "RolledBack", recovered(d).Reason)
+	})
+
+	t.Run("rollback without deployed version", func(t *testing.T) {
+		d, cfg := newDeploy(RecoverRollback, printing,
+			newRelease(1, release.StatusFailed, ""), newRelease(2, release.StatusPendingUpgrade, ""))
+		assert.NotNil(t, d.recoverPending(cfg))
+
+		last, err := cfg.Releases.Last("test")
+		assert.Nil(t, err)
+		assert.Equal(t, release.StatusPendingUpgrade, last.Info.Status)
+		assert.Equal(t, "ManualRecoveryRequired", recovered(d).Reason)
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		d, cfg := newDeploy(RecoverFail, printing,
+			newRelease(1, release.StatusDeployed, ""), newRelease(2, release.StatusPendingRollback, ""))
+		err := d.recoverPending(cfg)
+		assert.NotNil(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "rollback to version 2 was interrupted"))
+
+		last, err := cfg.Releases.Last("test")
+		assert.Nil(t, err)
+		assert.Equal(t, release.StatusPendingRollback, last.Info.Status)
+		assert.False(t, IsRecovered(last.Info))
+		cond := recovered(d)
+		assert.Equal(t, "ManualRecoveryRequired", cond.Reason)
+		assert.True(t, strings.Contains(cond.Message, util.RecoverPolicyAnnotation))
+	})
+
+	t.Run("not interrupted", func(t *testing.T) {
+		d, cfg := newDeploy(RecoverFail, printing, newRelease(1, release.StatusDeployed, ""))
+		assert.Nil(t, d.recoverPending(cfg))
+		assert.Equal(t, appv1.HelmRequestCondition{}, recovered(d))
+
+		d, cfg = newDeploy(RecoverFail, printing)
+		assert.Nil(t, d.recoverPending(cfg))
+	})
+}
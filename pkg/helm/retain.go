@@ -0,0 +1,138 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"helm.sh/helm/v3/pkg/kube"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/klog"
+)
+
+// kindRule matches resources by kind, and by apiVersion if it's not empty
+type kindRule struct {
+	apiVersion string
+	kind       string
+}
+
+// retention decides which resources are retained when uninstall a release
+type retention struct {
+	kinds    []kindRule
+	selector labels.Selector
+}
+
+// getRetention parses the retention rules of the helmrequest, returns nil if there are none.
+// Kinds are comma separated, each one is Kind, <version>/Kind or <group>/<version>/Kind.
+func getRetention(hr *appv1.HelmRequest) (*retention, error) {
+	if hr == nil || hr.Annotations == nil {
+		return nil, nil
+	}
+	kinds := hr.Annotations[util.RetainKindsAnnotation]
+	selector := hr.Annotations[util.RetainSelectorAnnotation]
+	if kinds == "" && selector == "" {
+		return nil, nil
+	}
+
+	r := &retention{}
+	for _, item := range strings.Split(kinds, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		rule := kindRule{kind: item}
+		if i := strings.LastIndex(item, "/"); i >= 0 {
+			rule.apiVersion = item[:i]
+			rule.kind = item[i+1:]
+		}
+		r.kinds = append(r.kinds, rule)
+	}
+	if selector != "" {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s error: %s", util.RetainSelectorAnnotation, err.Error())
+		}
+		r.selector = s
+	}
+	return r, nil
+}
+
+// matches checks if the resource should be retained, labels are read from the object in the manifest
+func (r *retention) matches(info *resource.Info) bool {
+	gvk := info.Object.GetObjectKind().GroupVersionKind()
+	if info.Mapping != nil {
+		gvk = info.Mapping.GroupVersionKind
+	}
+	for _, rule := range r.kinds {
+		if rule.kind == gvk.Kind && (rule.apiVersion == "" || rule.apiVersion == gvk.GroupVersion().String()) {
+			return true
+		}
+	}
+
+	if r.selector != nil {
+		accessor, err := meta.Accessor(info.Object)
+		if err == nil && r.selector.Matches(labels.Set(accessor.GetLabels())) {
+			return true
+		}
+	}
+	return false
+}
+
+// retainKubeClient wraps the helm kube client, the resources matching the retention rules are annotated
+// instead of deleted.
+type retainKubeClient struct {
+	kube.Interface
+
+	retention *retention
+	// from is the value of the RetainedFromAnnotation
+	from string
+}
+
+// Delete annotates the retained resources, and deletes the rest
+func (c *retainKubeClient) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	var errs []error
+	retained := resources.Filter(func(info *resource.Info) bool {
+		return c.retention.matches(info)
+	})
+	for _, info := range retained {
+		if err := c.markRetained(info); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	rest := resources.Difference(retained)
+	if len(rest) == 0 {
+		return &kube.Result{}, nil
+	}
+	return c.Interface.Delete(rest)
+}
+
+// markRetained adds the RetainedFromAnnotation to the resource
+func (c *retainKubeClient) markRetained(info *resource.Info) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{util.RetainedFromAnnotation: c.from},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	helper := resource.NewHelper(info.Client, info.Mapping)
+	if _, err := helper.Patch(info.Namespace, info.Name, types.MergePatchType, patch, nil); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("annotate retained resource %s/%s error: %s", info.Mapping.GroupVersionKind.Kind, info.Name, err.Error())
+	}
+	klog.Infof("retain resource %s/%s of release %s", info.Mapping.GroupVersionKind.Kind, info.Name, c.from)
+	return nil
+}
@@ -0,0 +1,99 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+func TestGetRetention(t *testing.T) {
+	retentionOf := func(annotations map[string]string) (*retention, error) {
+		return getRetention(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}})
+	}
+
+	r, err := getRetention(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	r, err = retentionOf(map[string]string{util.RetainKindsAnnotation: ""})
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+
+	r, err = retentionOf(map[string]string{util.RetainKindsAnnotation: "PersistentVolumeClaim, v1/Secret,apps/v1/StatefulSet,"})
+	assert.Nil(t, err)
+	assert.Equal(t, []kindRule{
+		{kind: "PersistentVolumeClaim"},
+		{apiVersion: "v1", kind: "Secret"},
+		{apiVersion: "apps/v1", kind: "StatefulSet"},
+	}, r.kinds)
+	assert.Nil(t, r.selector)
+
+	r, err = retentionOf(map[string]string{util.RetainSelectorAnnotation: "app in (db, cache)"})
+	assert.Nil(t, err)
+	assert.Len(t, 0, r.kinds)
+	assert.Equal(t, "app in (cache,db)", r.selector.String())
+
+	_, err = retentionOf(map[string]string{util.RetainSelectorAnnotation: "app in db"})
+	assert.NotNil(t, err)
+}
+
+func TestRetentionMatches(t *testing.T) {
+	infoOf := func(gvk schema.GroupVersionKind, labels map[string]string) *resource.Info {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetLabels(labels)
+		return &resource.Info{Object: obj, Mapping: &meta.RESTMapping{GroupVersionKind: gvk}}
+	}
+	secret := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	statefulSet := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}
+	oldStatefulSet := schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "StatefulSet"}
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+	r, err := getRetention(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		util.RetainKindsAnnotation:    "Secret,apps/v1/StatefulSet",
+		util.RetainSelectorAnnotation: "retain=true",
+	}}})
+	assert.Nil(t, err)
+
+	assert.True(t, r.matches(infoOf(secret, nil)))
+	assert.True(t, r.matches(infoOf(statefulSet, nil)))
+	assert.False(t, r.matches(infoOf(oldStatefulSet, nil)))
+	assert.False(t, r.matches(infoOf(deployment, nil)))
+	assert.True(t, r.matches(infoOf(deployment, map[string]string{"retain": "true"})))
+	assert.False(t, r.matches(infoOf(deployment, map[string]string{"retain": "false"})))
+}
+
+func TestRetainKubeClientDelete(t *testing.T) {
+	cms := newFakeConfigMaps()
+	retained := cms.info("data", map[string]string{"retain": "true"}, nil, true)
+	gone := cms.info("gone", map[string]string{"retain": "true"}, nil, false)
+	deleted := cms.info("config", nil, nil, true)
+
+	r, err := getRetention(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{util.RetainSelectorAnnotation: "retain=true"},
+	}})
+	assert.Nil(t, err)
+	inner := &buildKubeClient{}
+	c := &retainKubeClient{Interface: inner, retention: r, from: "default/app"}
+
+	_, errs := c.Delete(kube.ResourceList{retained, gone, deleted})
+	assert.Len(t, 0, errs)
+	// the retained ones are annotated instead of deleted, the ones already gone are ignored
+	assert.Equal(t, kube.ResourceList{deleted}, inner.deleted)
+	assert.Equal(t, map[string]string{
+		"data": `{"metadata":{"annotations":{"captain.cpaas.io/retained-from":"default/app"}}}`,
+	}, cms.patched)
+
+	// nothing is deleted if all of them are retained
+	inner.deleted = nil
+	_, errs = c.Delete(kube.ResourceList{retained})
+	assert.Len(t, 0, errs)
+	assert.Len(t, 0, inner.deleted)
+}
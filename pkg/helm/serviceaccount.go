@@ -0,0 +1,136 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// serviceAccountTokenExpiration is the requested lifetime of the ServiceAccount token, one sync should
+// never take longer than this.
+var serviceAccountTokenExpiration int64 = 3600
+
+// serviceAccountTokenRenewBefore is how long before the expiration a cached token is renewed, so a sync
+// started with the cached token can finish before it expires
+const serviceAccountTokenRenewBefore = 15 * time.Minute
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// serviceAccountTokens caches the ServiceAccount tokens by cluster, namespace and name, so a TokenRequest is
+// not issued for every deploy
+var serviceAccountTokens = struct {
+	sync.Mutex
+	items map[string]cachedToken
+}{items: map[string]cachedToken{}}
+
+// getServiceAccountName returns the ServiceAccount used to deploy the HelmRequest, empty means the cluster
+// admin token is used.
+func getServiceAccountName(hr *appv1.HelmRequest) string {
+	if hr == nil || hr.Annotations == nil {
+		return ""
+	}
+	return hr.Annotations[util.ServiceAccountAnnotation]
+}
+
+// serviceAccountCluster returns a copy of the target cluster info which uses the ServiceAccount's token.
+// The ServiceAccount lives in the release namespace (info.Namespace).
+func serviceAccountCluster(info *cluster.Info, name string) (*cluster.Info, error) {
+	token, err := getCachedServiceAccountToken(info, name)
+	if err != nil {
+		return nil, fmt.Errorf("get token for serviceaccount %s/%s error: %s", info.Namespace, name, err.Error())
+	}
+
+	ci := *info
+	ci.Token = token
+	// client certificates take precedence over token, drop them
+	ci.CertData = nil
+	ci.KeyData = nil
+	return &ci, nil
+}
+
+// getCachedServiceAccountToken returns the cached token of the ServiceAccount, a new one is requested if it's
+// not cached or about to expire.
+func getCachedServiceAccountToken(info *cluster.Info, name string) (string, error) {
+	key := fmt.Sprintf("%s/%s/%s/%s", info.Name, info.Endpoint, info.Namespace, name)
+	serviceAccountTokens.Lock()
+	item, ok := serviceAccountTokens.items[key]
+	serviceAccountTokens.Unlock()
+	if ok && time.Now().Add(serviceAccountTokenRenewBefore).Before(item.expiry) {
+		return item.token, nil
+	}
+
+	client, err := kubernetes.NewForConfig(info.ToRestConfig())
+	if err != nil {
+		return "", err
+	}
+	token, expiry, err := getServiceAccountToken(client, info.Namespace, name)
+	if err != nil {
+		return "", err
+	}
+	serviceAccountTokens.Lock()
+	serviceAccountTokens.items[key] = cachedToken{token: token, expiry: expiry}
+	serviceAccountTokens.Unlock()
+	return token, nil
+}
+
+// getServiceAccountToken request a token by the TokenRequest api, fallback to the legacy token secret if
+// the api is not supported by the cluster. Returns the token and when it expires, the legacy tokens never
+// expire but are rechecked after the same lifetime in case the secret is rotated.
+func getServiceAccountToken(client kubernetes.Interface, ns, name string) (string, time.Time, error) {
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &serviceAccountTokenExpiration,
+		},
+	}
+	expiry := time.Now().Add(time.Duration(serviceAccountTokenExpiration) * time.Second)
+	result, err := client.CoreV1().ServiceAccounts(ns).CreateToken(context.Background(), name, tr, metav1.CreateOptions{})
+	if err == nil {
+		if !result.Status.ExpirationTimestamp.IsZero() {
+			expiry = result.Status.ExpirationTimestamp.Time
+		}
+		return result.Status.Token, expiry, nil
+	}
+	if !apierrors.IsNotFound(err) && !apierrors.IsMethodNotSupported(err) {
+		return "", expiry, err
+	}
+
+	sa, err := client.CoreV1().ServiceAccounts(ns).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", expiry, err
+	}
+	for _, ref := range sa.Secrets {
+		secret, err := client.CoreV1().Secrets(ns).Get(context.Background(), ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", expiry, err
+		}
+		if secret.Type != corev1.SecretTypeServiceAccountToken {
+			continue
+		}
+		if token, ok := secret.Data[corev1.ServiceAccountTokenKey]; ok {
+			return string(token), expiry, nil
+		}
+	}
+	return "", expiry, fmt.Errorf("no token secret found")
+}
+
+// newServiceAccountRbacClient create a RbacClient for the ServiceAccount, so the denied resources can be
+// found before deploy.
+func newServiceAccountRbacClient(ns, name string) *RbacClient {
+	return &RbacClient{
+		user:   fmt.Sprintf("system:serviceaccount:%s:%s", ns, name),
+		groups: []string{"system:serviceaccounts", fmt.Sprintf("system:serviceaccounts:%s", ns)},
+	}
+}
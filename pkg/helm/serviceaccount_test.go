@@ -0,0 +1,140 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/gsamokovarov/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetServiceAccountToken(t *testing.T) {
+	expiry := metav1.NewTime(time.Now().Add(time.Hour).Truncate(time.Second))
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		assert.Equal(t, serviceAccountTokenExpiration, *tr.Spec.ExpirationSeconds)
+		tr.Status = authenticationv1.TokenRequestStatus{Token: "requested", ExpirationTimestamp: expiry}
+		return true, tr, nil
+	})
+
+	token, exp, err := getServiceAccountToken(client, "t1", "deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, "requested", token)
+	assert.True(t, exp.Equal(expiry.Time))
+}
+
+func TestGetServiceAccountTokenFromSecret(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "deployer"},
+			Secrets:    []corev1.ObjectReference{{Name: "deployer-dockercfg"}, {Name: "deployer-token"}},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "deployer-dockercfg"},
+			Type:       corev1.SecretTypeDockercfg,
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "deployer-token"},
+			Type:       corev1.SecretTypeServiceAccountToken,
+			Data:       map[string][]byte{corev1.ServiceAccountTokenKey: []byte("legacy")},
+		},
+	)
+	// the TokenRequest api is not supported
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(corev1.Resource("serviceaccounts/token"), "deployer")
+	})
+
+	before := time.Now()
+	token, expiry, err := getServiceAccountToken(client, "t1", "deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", token)
+	// rechecked after the same lifetime as the requested ones
+	assert.False(t, expiry.Before(before.Add(time.Duration(serviceAccountTokenExpiration)*time.Second)))
+
+	_, _, err = getServiceAccountToken(client, "t1", "missing")
+	assert.NotNil(t, err)
+}
+
+func TestGetCachedServiceAccountToken(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/t1/serviceaccounts/deployer/token", r.URL.Path)
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&authenticationv1.TokenRequest{
+			TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "TokenRequest"},
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               fmt.Sprintf("token-%d", requests),
+				ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+			},
+		})
+	}))
+	defer server.Close()
+	info := &cluster.Info{Name: "business", Endpoint: server.URL, Namespace: "t1"}
+
+	token, err := getCachedServiceAccountToken(info, "deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-1", token)
+	token, err = getCachedServiceAccountToken(info, "deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-1", token)
+	assert.Equal(t, 1, requests)
+
+	// renewed when it's about to expire
+	key := fmt.Sprintf("%s/%s/%s/%s", info.Name, info.Endpoint, info.Namespace, "deployer")
+	serviceAccountTokens.Lock()
+	serviceAccountTokens.items[key] = cachedToken{token: "token-1", expiry: time.Now().Add(serviceAccountTokenRenewBefore / 2)}
+	serviceAccountTokens.Unlock()
+	token, err = getCachedServiceAccountToken(info, "deployer")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-2", token)
+	assert.Equal(t, 2, requests)
+}
+
+func TestServiceAccountClusterConfig(t *testing.T) {
+	info := &cluster.Info{
+		Name:      "business",
+		Endpoint:  "https://business:6443",
+		Namespace: "t1",
+		Token:     "admin",
+		CAData:    []byte("ca"),
+		CertData:  []byte("admin-cert"),
+		KeyData:   []byte("admin-key"),
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s", info.Name, info.Endpoint, info.Namespace, "deployer")
+	serviceAccountTokens.Lock()
+	serviceAccountTokens.items[key] = cachedToken{token: "deployer-token", expiry: time.Now().Add(time.Hour)}
+	serviceAccountTokens.Unlock()
+
+	ci, err := serviceAccountCluster(info, "deployer")
+	assert.Nil(t, err)
+	// the client certificates would authenticate as the admin instead of the ServiceAccount
+	cfg, err := newConfigFlags(ci.ToRestConfig(), ci.Namespace, true).ToRESTConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "deployer-token", cfg.BearerToken)
+	assert.Equal(t, []byte("ca"), cfg.CAData)
+	assert.Len(t, 0, cfg.CertData)
+	assert.Len(t, 0, cfg.KeyData)
+
+	// the admin config keeps them
+	cfg, err = newConfigFlags(info.ToRestConfig(), info.Namespace, true).ToRESTConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("admin-cert"), cfg.CertData)
+	assert.Equal(t, []byte("admin-key"), cfg.KeyData)
+	assert.False(t, cfg.Insecure)
+}
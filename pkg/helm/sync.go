@@ -17,10 +17,12 @@ import (
 	"github.com/teris-io/shortid"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -48,22 +50,32 @@ type Deploy struct {
 	// Client is the crd client for hr
 	Client clientset.Interface
 
-	rbacClient *RbacClient
+	// AllowUnchecked allows the HelmRequests without a recorded creator to deploy without permission checks
+	AllowUnchecked bool
+
+	rbacClients []*RbacClient
+
+	// retention decides which resources are kept on uninstall
+	retention *retention
 
 	// Releases stores records of releases.
 	Releases *storage.Storage
 }
 
 type RbacClient struct {
-	// config is the rest config for target cluster, use it as base and add Impersonate info for rbac check
+	// config is the rest config for target cluster, used to create SubjectAccessReviews
 	config *rest.Config
 
-	// verb is the rbac verb we want to check. For chart install/update/delete, we want to check create/update/delete verb.
-	// this is a simple assumption, since create may contains craete/update/delete action...
-	verb string
+	// user and groups is the creator of the HelmRequest, whose permissions we want to check.
+	// For chart install/update/delete, we check create/patch/delete verb of each resource.
+	user   string
+	groups []string
 
 	// clientGetter is for helm kube client
 	clientGetter genericclioptions.RESTClientGetter
+
+	// kubeClient creates the SubjectAccessReviews, it's created from config if not set
+	kubeClient kubernetes.Interface
 }
 
 // NewDeploy create a new deploy struct with crd client set
@@ -104,6 +116,11 @@ func (d *Deploy) Sync() (*release.Release, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// the last upgrade/rollback may be interrupted, which blocks upgrade
+	if err := d.recoverPending(cfg); err != nil {
+		return nil, err
+	}
 	client := action.NewUpgrade(cfg)
 	// client.Force = true
 	client.Namespace = hr.GetReleaseNamespace()
@@ -151,7 +168,7 @@ func (d *Deploy) Sync() (*release.Release, error) {
 			return nil, err
 		}
 	default:
-		return nil, errors.New("Unsupported chart source of helmrequest spec")
+		return nil, NewPermanentError(errors.New("Unsupported chart source of helmrequest spec"))
 	}
 	d.HelmRequest.Status.Version = ch.Metadata.Version
 
@@ -166,6 +183,15 @@ func (d *Deploy) Sync() (*release.Release, error) {
 		}
 	}
 
+	// the same check as helm's, so the error is known to be permanent
+	coalesced, err := chartutil.CoalesceValues(ch, values)
+	if err != nil {
+		return nil, err
+	}
+	if err := chartutil.ValidateAgainstSchema(ch, coalesced); err != nil {
+		return nil, NewPermanentError(err)
+	}
+
 	if !d.Deployed {
 		log.Info("Release does not exist. Installing it now", "name", name)
 		resp, err := d.install(ch)
@@ -18,9 +18,9 @@ import (
 //only support map when iterate the map
 type Values = map[string]interface{}
 
-// Merges source and destination `chartutils.Values`, preferring values from the source Values
+// MergeValues merges source and destination `chartutils.Values`, preferring values from the source Values
 // This is slightly adapted from https://github.com/helm/helm/blob/2332b480c9cb70a0d8a85247992d6155fbe82416/cmd/helm/install.go#L359
-func mergeValues(dest, src Values) Values {
+func MergeValues(dest, src Values) Values {
 	for k, v := range src {
 		// If the key doesn't exist already, then just set the key to that value
 		if _, exists := dest[k]; !exists {
@@ -41,7 +41,7 @@ func mergeValues(dest, src Values) Values {
 			continue
 		}
 		// If we got to this point, it is a map in both, so merge them
-		dest[k] = mergeValues(destMap, nextMap)
+		dest[k] = MergeValues(destMap, nextMap)
 	}
 	return dest
 }
@@ -54,7 +54,7 @@ func getValues(hr *appv1.HelmRequest, cfg *rest.Config) (chartutil.Values, error
 	}
 
 	new := Values(hr.Spec.HelmValues.DeepCopy().Values)
-	values = mergeValues(values, new)
+	values = MergeValues(values, new)
 	klog.V(2).Infof("get values for helm request: %s  %+v", hr.GetName(), values)
 	return values, nil
 
@@ -77,7 +77,7 @@ func getValuesFromSource(hr *appv1.HelmRequest, cfg *rest.Config) (chartutil.Val
 				if err != nil {
 					return nil, err
 				}
-				values = mergeValues(values, v)
+				values = MergeValues(values, v)
 
 			}
 
@@ -86,7 +86,7 @@ func getValuesFromSource(hr *appv1.HelmRequest, cfg *rest.Config) (chartutil.Val
 				if err != nil {
 					return nil, err
 				}
-				values = mergeValues(values, v)
+				values = MergeValues(values, v)
 			}
 		}
 	}
@@ -0,0 +1,176 @@
+package release
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alauda/captain/pkg/release/storagedriver"
+	releaseclient "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// MigratedLabel is added to the release records of helm cli (Secrets/ConfigMaps) after they are imported
+// to Release CRs, they will not be imported again. Records exported by captain also have this label.
+const MigratedLabel = "captain.cpaas.io/migrated"
+
+// helmRecordKey is the key helm cli uses to store a release version
+func helmRecordKey(name string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version)
+}
+
+// helmRecordSelector selects the release records created by helm cli which are not imported yet. If name
+// is empty, all the releases are selected.
+func helmRecordSelector(name string) (string, error) {
+	set := labels.Set{"owner": "helm"}
+	if name != "" {
+		set["name"] = name
+	}
+	req, err := labels.NewRequirement(MigratedLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return "", err
+	}
+	return labels.SelectorFromSet(set).Add(*req).String(), nil
+}
+
+// ImportHelmReleases copies the history of the release stored by helm cli's Secret/ConfigMap drivers into
+// Release CRs, so captain can take over the release and upgrade it without reinstall. The original records
+// are kept and labeled as migrated. If name is empty, all the releases are imported, and if namespace is
+// empty, all the namespaces are searched. Returns the number of imported records.
+func ImportHelmReleases(cfg *rest.Config, namespace, name string) (int, error) {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	relClient, err := releaseclient.NewForConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	selector, err := helmRecordSelector(name)
+	if err != nil {
+		return 0, err
+	}
+	opts := metav1.ListOptions{LabelSelector: selector}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{"%s":"true"}}}`, MigratedLabel))
+
+	count := 0
+
+	secrets, err := kubeClient.CoreV1().Secrets(namespace).List(context.Background(), opts)
+	if err != nil {
+		return count, err
+	}
+	for _, item := range secrets.Items {
+		source := driver.NewSecrets(kubeClient.CoreV1().Secrets(item.Namespace))
+		imported, err := importRecord(source, relClient, item.Namespace, item.Name)
+		if err != nil {
+			return count, err
+		}
+		if _, err := kubeClient.CoreV1().Secrets(item.Namespace).Patch(context.Background(), item.Name,
+			types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return count, err
+		}
+		if imported {
+			count++
+		}
+	}
+
+	cms, err := kubeClient.CoreV1().ConfigMaps(namespace).List(context.Background(), opts)
+	if err != nil {
+		return count, err
+	}
+	for _, item := range cms.Items {
+		source := driver.NewConfigMaps(kubeClient.CoreV1().ConfigMaps(item.Namespace))
+		imported, err := importRecord(source, relClient, item.Namespace, item.Name)
+		if err != nil {
+			return count, err
+		}
+		if _, err := kubeClient.CoreV1().ConfigMaps(item.Namespace).Patch(context.Background(), item.Name,
+			types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return count, err
+		}
+		if imported {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// importRecord decode one release record from the helm driver, and store it as a Release CR with the same key.
+// If the Release of the same version already exists (eg: a previous import failed to label the record), the
+// record is treated as imported and false is returned.
+func importRecord(source driver.Driver, relClient releaseclient.Interface, namespace, key string) (bool, error) {
+	rls, err := source.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("decode helm release %s/%s error: %s", namespace, key, err.Error())
+	}
+	target := storagedriver.NewReleases(relClient.AppV1alpha1().Releases(namespace))
+	existing, err := target.Get(key)
+	if err == nil {
+		if existing.Name == rls.Name && existing.Version == rls.Version {
+			klog.Infof("helm release %s/%s is already imported, skip it", namespace, key)
+			return false, nil
+		}
+		return false, fmt.Errorf("import helm release %s/%s error: %s", namespace, key, driver.ErrReleaseExists)
+	}
+	if errors.Cause(err) != driver.ErrReleaseNotFound {
+		return false, fmt.Errorf("check helm release %s/%s error: %s", namespace, key, err.Error())
+	}
+	if err := target.Create(key, rls); err != nil {
+		return false, fmt.Errorf("import helm release %s/%s error: %s", namespace, key, err.Error())
+	}
+	klog.Infof("imported helm release %s/%s, status: %s", namespace, key, rls.Info.Status)
+	return true, nil
+}
+
+// ExportReleases writes the history of the release stored in Release CRs out as helm cli's Secret records in
+// the same namespace, so the release can be managed by the stock helm cli. Existing records are overwritten.
+// Returns the number of exported records.
+func ExportReleases(cfg *rest.Config, namespace, name string) (int, error) {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	relClient, err := releaseclient.NewForConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	source := storagedriver.NewReleases(relClient.AppV1alpha1().Releases(namespace))
+	history, err := source.Query(map[string]string{"name": name, "owner": "helm"})
+	if err != nil {
+		if errors.Cause(err) == driver.ErrReleaseNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	target := driver.NewSecrets(kubeClient.CoreV1().Secrets(namespace))
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{"%s":"true"}}}`, MigratedLabel))
+	count := 0
+	for _, rls := range history {
+		key := helmRecordKey(rls.Name, rls.Version)
+		err := target.Create(key, rls)
+		if errors.Cause(err) == driver.ErrReleaseExists {
+			err = target.Update(key, rls)
+		}
+		if err != nil {
+			return count, fmt.Errorf("export release %s/%s error: %s", namespace, key, err.Error())
+		}
+		// avoid to be imported back
+		if _, err := kubeClient.CoreV1().Secrets(namespace).Patch(context.Background(), key,
+			types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return count, err
+		}
+		klog.Infof("exported release %s/%s, status: %s", namespace, key, rls.Info.Status)
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,195 @@
+package storagedriver
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// Large releases (eg: charts with lots of CRDs) may exceed the object size limit of etcd. If the encoded data
+// of a release is too large, it's moved out to several chunk objects, the release object only keeps the number
+// and revision of it's chunks in annotations.
+//
+// The following labels are used within each chunk object:
+//
+//    "owner"            - owner of the object, "captain-chunk". So chunks are not listed as releases.
+//    "chunk-of-name"    - name of the release.
+//    "chunk-of-version" - version of the release.
+//    "chunk-revision"   - hash of the data, chunks of a new revision are created before the release object
+//                         points to it, and the old ones are deleted after that.
+//    "chunk-index"      - index of the chunk.
+const (
+	chunkOwner         = "captain-chunk"
+	chunkNameLabel     = "chunk-of-name"
+	chunkVersionLabel  = "chunk-of-version"
+	chunkRevisionLabel = "chunk-revision"
+	chunkIndexLabel    = "chunk-index"
+
+	chunksAnnotation        = "captain.cpaas.io/chunks"
+	chunkRevisionAnnotation = "captain.cpaas.io/chunk-revision"
+)
+
+var (
+	// maxDataSize is the max size of the encoded data stored in a release object
+	maxDataSize = 1024 * 1024
+
+	// chunkSize is the size of the data stored in a chunk object
+	chunkSize = 1024 * 1024
+)
+
+// chunkPayload is the data moved out to chunks
+type chunkPayload struct {
+	Chart    string `json:"chart"`
+	Config   string `json:"config"`
+	Hooks    string `json:"hooks"`
+	Manifest string `json:"manifest"`
+}
+
+func dataSize(obj *v1alpha1.Release) int {
+	return len(obj.Spec.ChartData) + len(obj.Spec.ConfigData) + len(obj.Spec.HooksData) + len(obj.Spec.ManifestData)
+}
+
+// splitRelease moves the data of the release object into chunks if it's too large. The returned chunks
+// should be saved before the release object.
+func splitRelease(obj *v1alpha1.Release) ([]*v1alpha1.Release, error) {
+	if dataSize(obj) <= maxDataSize {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(chunkPayload{
+		Chart:    obj.Spec.ChartData,
+		Config:   obj.Spec.ConfigData,
+		Hooks:    obj.Spec.HooksData,
+		Manifest: obj.Spec.ManifestData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	hasher := fnv.New64a()
+	hasher.Write(data)
+	revision := strconv.FormatUint(hasher.Sum64(), 16)
+
+	var chunks []*v1alpha1.Release
+	for i := 0; i*chunkSize < len(data); i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		var chunk v1alpha1.Release
+		chunk.Name = fmt.Sprintf("%s.chunk-%s-%d", obj.Name, revision, i)
+		chunk.Namespace = obj.Namespace
+		chunk.Labels = map[string]string{
+			"owner":            chunkOwner,
+			chunkNameLabel:     obj.Spec.Name,
+			chunkVersionLabel:  strconv.Itoa(obj.Spec.Version),
+			chunkRevisionLabel: revision,
+			chunkIndexLabel:    strconv.Itoa(i),
+		}
+		chunk.Spec.ChartData = string(data[i*chunkSize : end])
+		chunks = append(chunks, &chunk)
+	}
+
+	obj.Spec.ChartData = ""
+	obj.Spec.ConfigData = ""
+	obj.Spec.HooksData = ""
+	obj.Spec.ManifestData = ""
+	if obj.Annotations == nil {
+		obj.Annotations = map[string]string{}
+	}
+	obj.Annotations[chunksAnnotation] = strconv.Itoa(len(chunks))
+	obj.Annotations[chunkRevisionAnnotation] = revision
+	return chunks, nil
+}
+
+// chunkSelector selects the chunks of a release version, if revision is not empty, only chunks of other
+// revisions are selected.
+func chunkSelector(name string, version int, exceptRevision string) string {
+	selector := kblabels.Set{
+		"owner":           chunkOwner,
+		chunkNameLabel:    name,
+		chunkVersionLabel: strconv.Itoa(version),
+	}.AsSelector()
+	if exceptRevision != "" {
+		req, _ := kblabels.NewRequirement(chunkRevisionLabel, selection.NotEquals, []string{exceptRevision})
+		selector = selector.Add(*req)
+	}
+	return selector.String()
+}
+
+// saveChunks creates the chunks. Chunks with the same name have the same data, so they are skipped
+func (rel *Releases) saveChunks(chunks []*v1alpha1.Release) error {
+	for _, chunk := range chunks {
+		if _, err := rel.impl.Create(chunk); err != nil && !apierrors.IsAlreadyExists(err) {
+			rel.Log("save chunk %s error: %s", chunk.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// loadChunks reads the data of the release object back from it's chunks, if it's chunked
+func (rel *Releases) loadChunks(obj *v1alpha1.Release) error {
+	if obj.Annotations == nil || obj.Annotations[chunksAnnotation] == "" {
+		return nil
+	}
+	count, err := strconv.Atoi(obj.Annotations[chunksAnnotation])
+	if err != nil {
+		return err
+	}
+	revision := obj.Annotations[chunkRevisionAnnotation]
+
+	selector := kblabels.Set{
+		"owner":            chunkOwner,
+		chunkNameLabel:     obj.Spec.Name,
+		chunkVersionLabel:  strconv.Itoa(obj.Spec.Version),
+		chunkRevisionLabel: revision,
+	}.AsSelector().String()
+	list, err := rel.impl.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	if len(list.Items) != count {
+		return fmt.Errorf("release %s has %d chunks, found %d", obj.Name, count, len(list.Items))
+	}
+
+	items := list.Items
+	sort.Slice(items, func(i, j int) bool {
+		a, _ := strconv.Atoi(items[i].Labels[chunkIndexLabel])
+		b, _ := strconv.Atoi(items[j].Labels[chunkIndexLabel])
+		return a < b
+	})
+	var builder strings.Builder
+	for _, item := range items {
+		builder.WriteString(item.Spec.ChartData)
+	}
+
+	var payload chunkPayload
+	if err := json.Unmarshal([]byte(builder.String()), &payload); err != nil {
+		return err
+	}
+	obj.Spec.ChartData = payload.Chart
+	obj.Spec.ConfigData = payload.Config
+	obj.Spec.HooksData = payload.Hooks
+	obj.Spec.ManifestData = payload.Manifest
+	return nil
+}
+
+// deleteChunks deletes the chunks of a release version except the ones of the given revision
+func (rel *Releases) deleteChunks(name string, version int, exceptRevision string) error {
+	opts := metav1.ListOptions{LabelSelector: chunkSelector(name, version, exceptRevision)}
+	if err := rel.impl.DeleteCollection(&metav1.DeleteOptions{}, opts); err != nil {
+		rel.Log("delete chunks of %s.v%d error: %s", name, version, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package storagedriver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	rspb "helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestChunkedRelease(t *testing.T) {
+	maxDataSize, chunkSize = 64, 100
+	defer func() {
+		maxDataSize, chunkSize = 1024*1024, 1024*1024
+	}()
+
+	clientset := fake.NewSimpleClientset()
+	client := clientset.AppV1alpha1().Releases("default")
+	rel := NewReleases(client)
+
+	rls := &rspb.Release{
+		Name:      "big",
+		Namespace: "default",
+		Version:   1,
+		Info:      &rspb.Info{Status: rspb.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "big", Version: "0.1.0"}},
+		Config:    map[string]interface{}{"replicas": float64(1)},
+		Manifest:  strings.Repeat("kind: ConfigMap\n", 100),
+	}
+	key := "sh.helm.release.v1.big.v1"
+	assert.Nil(t, rel.Create(key, rls))
+
+	all, err := client.List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.True(t, len(all.Items) > 2)
+
+	obj, err := client.Get(key, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", obj.Spec.ManifestData)
+
+	got, err := rel.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, rls.Manifest, got.Manifest)
+	assert.Equal(t, rls.Config, got.Config)
+
+	// status changes reuse the chunks
+	rls.Info.Status = rspb.StatusSuperseded
+	assert.Nil(t, rel.Update(key, rls))
+	after, err := client.List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, len(all.Items), len(after.Items))
+
+	list, err := rel.List(func(*rspb.Release) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, rspb.StatusSuperseded, list[0].Info.Status)
+	assert.Equal(t, rls.Manifest, list[0].Manifest)
+
+	// the chunks are deleted before the release object, a failed delete can be retried without leaking them
+	var verbs []string
+	clientset.PrependReactor("*", "releases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		verbs = append(verbs, action.GetVerb())
+		if action.GetVerb() == "delete" && len(verbs) < 4 {
+			return true, nil, errors.New("delete failed")
+		}
+		return false, nil, nil
+	})
+	_, err = rel.Delete(key)
+	assert.NotNil(t, err)
+	_, err = rel.Delete(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"get", "delete-collection", "delete", "get", "delete-collection", "delete"}, verbs)
+	_, err = client.Get(key, metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
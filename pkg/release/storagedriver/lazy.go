@@ -0,0 +1,150 @@
+package storagedriver
+
+import (
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	rspb "helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// pageSize is the number of release objects fetched in one list request, release objects contains the whole
+// chart, list them all at once may cause OOM
+var pageSize int64 = 100
+
+// ListOptions selects releases by labels on the server side
+type ListOptions struct {
+	// Name selects the versions of a release, empty means all the releases
+	Name string
+
+	// Statuses selects releases in any of the statuses, empty means all the statuses
+	Statuses []rspb.Status
+
+	// Limit is the max number of releases returned in one page, default to pageSize
+	Limit int64
+
+	// Continue is the token returned by the previous page
+	Continue string
+}
+
+func (o ListOptions) selector() (string, error) {
+	set := kblabels.Set{"owner": "helm"}
+	if o.Name != "" {
+		set["name"] = o.Name
+	}
+	selector := kblabels.SelectorFromSet(set)
+	if len(o.Statuses) > 0 {
+		var values []string
+		for _, s := range o.Statuses {
+			values = append(values, s.String())
+		}
+		req, err := kblabels.NewRequirement("status", selection.In, values)
+		if err != nil {
+			return "", err
+		}
+		selector = selector.Add(*req)
+	}
+	return selector.String(), nil
+}
+
+// LazyRelease contains the metadata of a release version, the chart/config/hooks/manifest are only fetched and
+// decoded when Release() is called.
+type LazyRelease struct {
+	// Key is the name of the release object
+	Key string
+	// UID is the uid of the release object
+	UID types.UID
+
+	Name      string
+	Namespace string
+	Version   int
+	Info      *rspb.Info
+
+	driver  *Releases
+	release *rspb.Release
+}
+
+// Meta returns a release which only contains the metadata
+func (l *LazyRelease) Meta() *rspb.Release {
+	return &rspb.Release{
+		Name:      l.Name,
+		Namespace: l.Namespace,
+		Version:   l.Version,
+		Info:      l.Info,
+	}
+}
+
+// Release fetches and decodes the whole release, the result is cached
+func (l *LazyRelease) Release() (*rspb.Release, error) {
+	if l.release != nil {
+		return l.release, nil
+	}
+	rls, err := l.driver.Get(l.Key)
+	if err != nil {
+		return nil, err
+	}
+	l.release = rls
+	return rls, nil
+}
+
+// ListLazy returns one page of the releases selected by opts, and the token for the next page, which is empty
+// if there are no more pages. Only the metadata of each release is kept.
+func (rel *Releases) ListLazy(opts ListOptions) ([]*LazyRelease, string, error) {
+	selector, err := opts.selector()
+	if err != nil {
+		return nil, "", err
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = pageSize
+	}
+
+	list, err := rel.impl.List(metav1.ListOptions{
+		LabelSelector: selector,
+		Limit:         limit,
+		Continue:      opts.Continue,
+	})
+	if err != nil {
+		rel.Log("list lazy: failed to list: %s", err)
+		return nil, "", err
+	}
+
+	results := make([]*LazyRelease, 0, len(list.Items))
+	for i := range list.Items {
+		results = append(results, rel.newLazyRelease(&list.Items[i]))
+	}
+	return results, list.Continue, nil
+}
+
+func (rel *Releases) newLazyRelease(obj *v1alpha1.Release) *LazyRelease {
+	meta, _ := decodeRawRelease(obj)
+	return &LazyRelease{
+		Key:       obj.GetName(),
+		UID:       obj.GetUID(),
+		Name:      meta.Name,
+		Namespace: meta.Namespace,
+		Version:   meta.Version,
+		Info:      meta.Info,
+		driver:    rel,
+	}
+}
+
+// walk lists the release objects page by page, fn is called for each of them. The objects of a page are
+// released after the page is processed, so the memory usage does not grow with the number of releases.
+func (rel *Releases) walk(selector string, fn func(obj *v1alpha1.Release)) error {
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: pageSize}
+	for {
+		list, err := rel.impl.List(opts)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			fn(&list.Items[i])
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		opts.Continue = list.Continue
+	}
+}
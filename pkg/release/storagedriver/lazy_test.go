@@ -0,0 +1,49 @@
+package storagedriver
+
+import (
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/client/clientset/versioned/fake"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	rspb "helm.sh/helm/v3/pkg/release"
+)
+
+func TestListOptionsSelector(t *testing.T) {
+	selector, err := ListOptions{}.selector()
+	assert.Nil(t, err)
+	assert.Equal(t, "owner=helm", selector)
+
+	selector, err = ListOptions{
+		Name:     "nginx",
+		Statuses: []rspb.Status{rspb.StatusDeployed, rspb.StatusFailed},
+	}.selector()
+	assert.Nil(t, err)
+	assert.Equal(t, "name=nginx,owner=helm,status in (deployed,failed)", selector)
+}
+
+func TestListLazy(t *testing.T) {
+	rel := NewReleases(fake.NewSimpleClientset().AppV1alpha1().Releases("default"))
+	for i, status := range []rspb.Status{rspb.StatusSuperseded, rspb.StatusDeployed} {
+		rls := &rspb.Release{
+			Name:      "nginx",
+			Namespace: "default",
+			Version:   i + 1,
+			Info:      &rspb.Info{Status: status},
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "nginx"}},
+			Manifest:  "kind: ConfigMap",
+		}
+		assert.Nil(t, rel.Create(makeKey(rls.Name, rls.Version), rls))
+	}
+
+	items, next, err := rel.ListLazy(ListOptions{Name: "nginx", Statuses: []rspb.Status{rspb.StatusDeployed}})
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, 2, items[0].Version)
+	assert.Equal(t, rspb.StatusDeployed, items[0].Meta().Info.Status)
+
+	rls, err := items[0].Release()
+	assert.Nil(t, err)
+	assert.Equal(t, "kind: ConfigMap", rls.Manifest)
+}
@@ -88,6 +88,10 @@ func (rel *Releases) Get(key string) (*rspb.Release, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := rel.loadChunks(obj); err != nil {
+		rel.Log("get: failed to load chunks of %q: %s", key, err)
+		return nil, err
+	}
 	// found the object, decode the base64 data string
 	r, err := decodeRelease(obj)
 	if err != nil {
@@ -101,29 +105,31 @@ func (rel *Releases) Get(key string) (*rspb.Release, error) {
 // List fetches all releases and returns the list releases such
 // that filter(release) == true. An error is returned if the
 // object fails to retrieve the releases.
+// The filter is called with the metadata (name, namespace, version and info) of each release, only the
+// matched ones are decoded. Releases are listed page by page to avoid OOM.
 func (rel *Releases) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 	lsel := kblabels.Set{"owner": "helm"}.AsSelector()
-	opts := metav1.ListOptions{LabelSelector: lsel.String()}
-
-	list, err := rel.impl.List(opts)
-	if err != nil {
-		rel.Log("list: failed to list: %s", err)
-		return nil, err
-	}
 
 	var results []*rspb.Release
-
-	// iterate over the objects object list
-	// and decode each release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(&item)
-		if err != nil {
-			rel.Log("list: failed to decode release: %v: %s", item, err)
-			continue
+	err := rel.walk(lsel.String(), func(item *v1alpha1.Release) {
+		meta, _ := decodeRawRelease(item)
+		if !filter(meta) {
+			return
 		}
-		if filter(rls) {
-			results = append(results, rls)
+		if err := rel.loadChunks(item); err != nil {
+			rel.Log("list: failed to load chunks of release: %s: %s", item.Name, err)
+			return
+		}
+		rls, err := decodeRelease(item)
+		if err != nil {
+			rel.Log("list: failed to decode release: %s: %s", item.Name, err)
+			return
 		}
+		results = append(results, rls)
+	})
+	if err != nil {
+		rel.Log("list: failed to list: %s", err)
+		return nil, err
 	}
 	return results, nil
 }
@@ -139,27 +145,29 @@ func (rel *Releases) Query(labels map[string]string) ([]*rspb.Release, error) {
 		ls[k] = v
 	}
 
-	opts := metav1.ListOptions{LabelSelector: ls.AsSelector().String()}
-
-	list, err := rel.impl.List(opts)
+	var results []*rspb.Release
+	found := false
+	err := rel.walk(ls.AsSelector().String(), func(item *v1alpha1.Release) {
+		found = true
+		if err := rel.loadChunks(item); err != nil {
+			rel.Log("query: failed to load chunks of release: %s: %s", item.Name, err)
+			return
+		}
+		rls, err := decodeRelease(item)
+		if err != nil {
+			rel.Log("query: failed to decode release: %s", err)
+			return
+		}
+		results = append(results, rls)
+	})
 	if err != nil {
 		rel.Log("query: failed to query with labels: %s", err)
 		return nil, err
 	}
 
-	if len(list.Items) == 0 {
+	if !found {
 		return nil, driver.ErrReleaseNotFound
 	}
-
-	var results []*rspb.Release
-	for _, item := range list.Items {
-		rls, err := decodeRelease(&item)
-		if err != nil {
-			rel.Log("query: failed to decode release: %s", err)
-			continue
-		}
-		results = append(results, rls)
-	}
 	return results, nil
 }
 
@@ -178,6 +186,14 @@ func (rel *Releases) Create(key string, rls *rspb.Release) error {
 		rel.Log("create: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	// chunks are saved before the release object, so a release is always complete when it's found
+	chunks, err := splitRelease(obj)
+	if err != nil {
+		return err
+	}
+	if err := rel.saveChunks(chunks); err != nil {
+		return err
+	}
 	// push the object out into the kubiverse
 	if _, err := rel.impl.Create(obj); err != nil {
 		if apierrors.IsAlreadyExists(err) {
@@ -186,6 +202,9 @@ func (rel *Releases) Create(key string, rls *rspb.Release) error {
 		}
 
 		rel.Log("create: failed to create: %s", err)
+		if chunks != nil {
+			rel.deleteChunks(rls.Name, rls.Version, "")
+		}
 		return err
 	}
 	return nil
@@ -206,6 +225,13 @@ func (rel *Releases) Update(key string, rls *rspb.Release) error {
 		rel.Log("update: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	chunks, err := splitRelease(obj)
+	if err != nil {
+		return err
+	}
+	if err := rel.saveChunks(chunks); err != nil {
+		return err
+	}
 
 	old, err := rel.getRawRelease(key)
 	if err != nil {
@@ -221,6 +247,12 @@ func (rel *Releases) Update(key string, rls *rspb.Release) error {
 		rel.Log("update: failed to update: %s", err)
 		return err
 	}
+
+	// the release object points to the new chunks now, clean up the old ones
+	if old != nil && old.Annotations[chunksAnnotation] != "" &&
+		old.Annotations[chunkRevisionAnnotation] != obj.Annotations[chunkRevisionAnnotation] {
+		rel.deleteChunks(rls.Name, rls.Version, obj.Annotations[chunkRevisionAnnotation])
+	}
 	return nil
 }
 
@@ -237,6 +269,13 @@ func (rel *Releases) Delete(key string) (rls *rspb.Release, err error) {
 		return nil, err
 	}
 
+	// delete the chunks first, the release object is the only thing to find them. If it fails to delete the
+	// release object later, the delete is retried and the chunks are not leaked.
+	if old.Annotations[chunksAnnotation] != "" {
+		if err := rel.deleteChunks(old.Spec.Name, old.Spec.Version, ""); err != nil {
+			return rls, err
+		}
+	}
 	// delete the release
 	if err = rel.impl.Delete(old.GetName(), &metav1.DeleteOptions{}); err != nil {
 		return rls, err
@@ -0,0 +1,117 @@
+package syncwindow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed cron expression: minute hour day-of-month month day-of-week
+type schedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// domAny/dowAny are true if the field is *, when both are restricted a day matches either of them
+	domAny, dowAny bool
+}
+
+// field is the range of a field of the cron expression
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses a standard 5 fields cron expression. Each field supports *, <n>, <a>-<b>, a step
+// (*/<n> or <a>-<b>/<n>) and comma separated lists of them. Sunday is 0 or 7 in day of week.
+func parseSchedule(spec string) (*schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected %d fields, got %d", spec, len(fields), len(parts))
+	}
+
+	var sets []map[int]bool
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err.Error())
+		}
+		sets = append(sets, set)
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+// parseField parses one field into the set of the values matched
+func parseField(value string, f field) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, item := range strings.Split(value, ",") {
+		expr, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %s: %s", f.name, item)
+			}
+			expr, step = item[:i], n
+		}
+
+		start, end := f.min, f.max
+		if expr != "*" {
+			bounds := strings.SplitN(expr, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", f.name, item)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid %s: %s", f.name, item)
+				}
+			} else if step > 1 {
+				// <n>/<step> means from n to the max
+				end = f.max
+			}
+		}
+		if start < f.min || end > f.max || start > end {
+			return nil, fmt.Errorf("%s out of range [%d, %d]: %s", f.name, f.min, f.max, item)
+		}
+		for v := start; v <= end; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matches checks if the minute of t matches the schedule, in the location of t
+func (s *schedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
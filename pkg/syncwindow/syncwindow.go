@@ -0,0 +1,163 @@
+// Package syncwindow decides when a HelmRequest is allowed to be synced, by the schedule based allow/deny windows
+package syncwindow
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	// the captain image has no tz database
+	_ "time/tzdata"
+
+	"github.com/ghodss/yaml"
+)
+
+// Kind is the kind of a window
+type Kind string
+
+const (
+	// Allow windows are when the syncs are allowed, if any of them are defined, syncs are only allowed in them
+	Allow Kind = "allow"
+	// Deny windows are when the syncs are denied, they win over the allow windows
+	Deny Kind = "deny"
+)
+
+// searchHorizon is how far to search for the next open time
+const searchHorizon = 31 * 24 * time.Hour
+
+// Window is a schedule based window, it opens at every time the schedule matches and lasts for the duration
+type Window struct {
+	Kind Kind `json:"kind"`
+	// Schedule is a cron expression of when the window opens
+	Schedule string `json:"schedule"`
+	// Duration is how long the window lasts, like 2h or 30m
+	Duration string `json:"duration"`
+	// TimeZone is the IANA time zone of the schedule, default to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Parse parses a yaml/json list of windows, and validates them
+func Parse(data string) ([]Window, error) {
+	var windows []Window
+	if err := yaml.Unmarshal([]byte(data), &windows); err != nil {
+		return nil, err
+	}
+	for _, w := range windows {
+		if _, err := w.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return windows, nil
+}
+
+// compiled is a Window parsed
+type compiled struct {
+	kind     Kind
+	schedule *schedule
+	duration time.Duration
+	location *time.Location
+}
+
+func (w Window) compile() (*compiled, error) {
+	if w.Kind != Allow && w.Kind != Deny {
+		return nil, fmt.Errorf("invalid window kind %q, should be allow or deny", w.Kind)
+	}
+	s, err := parseSchedule(w.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil || d < time.Minute {
+		return nil, fmt.Errorf("invalid window duration %q, should be at least 1m", w.Duration)
+	}
+	loc := time.UTC
+	if w.TimeZone != "" {
+		if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid window time zone %q: %s", w.TimeZone, err.Error())
+		}
+	}
+	return &compiled{kind: w.Kind, schedule: s, duration: d, location: loc}, nil
+}
+
+// interval is a time range a window is open, [start, end)
+type interval struct {
+	start, end time.Time
+}
+
+func (i interval) contains(t time.Time) bool {
+	return !t.Before(i.start) && t.Before(i.end)
+}
+
+// intervals returns the times the window is open in [from, to)
+func (c *compiled) intervals(from, to time.Time) []interval {
+	var result []interval
+	t := from.Add(-c.duration).In(c.location).Truncate(time.Minute)
+	for ; t.Before(to); t = t.Add(time.Minute) {
+		if c.schedule.matches(t) {
+			if end := t.Add(c.duration); end.After(from) {
+				result = append(result, interval{start: t, end: end})
+			}
+		}
+	}
+	return result
+}
+
+// Next returns whether syncs are allowed at now. If not, it also returns the next time they are allowed, which
+// is zero if there is none in a month.
+func Next(windows []Window, now time.Time) (bool, time.Time, error) {
+	if len(windows) == 0 {
+		return true, time.Time{}, nil
+	}
+
+	to := now.Add(searchHorizon)
+	var allows, denies []interval
+	// the allow windows may not open in the horizon, but syncs are still only allowed in them
+	hasAllows := false
+	for _, w := range windows {
+		c, err := w.compile()
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if c.kind == Allow {
+			hasAllows = true
+			allows = append(allows, c.intervals(now, to)...)
+		} else {
+			denies = append(denies, c.intervals(now, to)...)
+		}
+	}
+
+	allowed := func(t time.Time) bool {
+		for _, i := range denies {
+			if i.contains(t) {
+				return false
+			}
+		}
+		if !hasAllows {
+			return true
+		}
+		for _, i := range allows {
+			if i.contains(t) {
+				return true
+			}
+		}
+		return false
+	}
+	if allowed(now) {
+		return true, time.Time{}, nil
+	}
+
+	// the state only changes when a window opens or closes
+	var candidates []time.Time
+	for _, i := range denies {
+		candidates = append(candidates, i.end)
+	}
+	for _, i := range allows {
+		candidates = append(candidates, i.start)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, t := range candidates {
+		if t.After(now) && t.Before(to) && allowed(t) {
+			return false, t, nil
+		}
+	}
+	return false, time.Time{}, nil
+}
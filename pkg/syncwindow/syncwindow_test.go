@@ -0,0 +1,83 @@
+package syncwindow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gsamokovarov/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := parseSchedule("*/15 22-23 * * 1-5")
+	assert.Nil(t, err)
+	// Monday
+	assert.True(t, s.matches(time.Date(2021, 9, 13, 22, 30, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 9, 13, 22, 31, 0, 0, time.UTC)))
+	// Sunday
+	assert.False(t, s.matches(time.Date(2021, 9, 12, 22, 30, 0, 0, time.UTC)))
+
+	s, err = parseSchedule("0 0 1 * 7")
+	assert.Nil(t, err)
+	// the 1st or Sunday
+	assert.True(t, s.matches(time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, s.matches(time.Date(2021, 9, 12, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 9, 13, 0, 0, 0, 0, time.UTC)))
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseSchedule(spec)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNext(t *testing.T) {
+	windows, err := Parse(`
+- kind: allow
+  schedule: "0 22 * * *"
+  duration: 4h
+  timeZone: Asia/Shanghai
+- kind: deny
+  schedule: "0 0 * * 6"
+  duration: 48h
+  timeZone: Asia/Shanghai
+`)
+	assert.Nil(t, err)
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+
+	// Monday 23:00, in the allow window
+	ok, _, err := Next(windows, time.Date(2021, 9, 13, 23, 0, 0, 0, shanghai))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Tuesday 1:00, in the window opened yesterday
+	ok, _, _ = Next(windows, time.Date(2021, 9, 14, 1, 0, 0, 0, shanghai))
+	assert.True(t, ok)
+
+	// Tuesday 12:00, wait for 22:00
+	ok, next, _ := Next(windows, time.Date(2021, 9, 14, 12, 0, 0, 0, shanghai))
+	assert.False(t, ok)
+	assert.True(t, next.Equal(time.Date(2021, 9, 14, 22, 0, 0, 0, shanghai)))
+
+	// Friday 23:00, in the allow window until Saturday 00:00 when the deny window opens
+	ok, _, _ = Next(windows, time.Date(2021, 9, 17, 23, 0, 0, 0, shanghai))
+	assert.True(t, ok)
+
+	// Saturday 1:00, denied through the weekend, Sunday 22:00 is still denied
+	ok, next, _ = Next(windows, time.Date(2021, 9, 18, 1, 0, 0, 0, shanghai))
+	assert.False(t, ok)
+	assert.True(t, next.Equal(time.Date(2021, 9, 20, 0, 0, 0, 0, shanghai)))
+
+	ok, _, _ = Next(nil, time.Now())
+	assert.True(t, ok)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range []string{
+		`[{"kind": "maybe", "schedule": "* * * * *", "duration": "1h"}]`,
+		`[{"kind": "allow", "schedule": "* * * * *", "duration": "10s"}]`,
+		`[{"kind": "allow", "schedule": "* * * * *", "duration": "1h", "timeZone": "Mars/Olympus"}]`,
+		`kind: allow`,
+	} {
+		_, err := Parse(data)
+		assert.NotNil(t, err)
+	}
+}
@@ -18,3 +18,21 @@ func NewOwnerRef(owner metav1.Object, gvk schema.GroupVersionKind) *metav1.Owner
 		Controller:         &isController,
 	}
 }
+
+// CopyCreator copies the creator and modifier annotations recorded on the owner to the object generated from it.
+// The ones already on the object are dropped, so the generated object is checked against the permissions of the
+// users who created and last updated the owner, instead of captain's.
+func CopyCreator(owner, obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range []string{CreatorAnnotation, CreatorGroupsAnnotation, ModifierAnnotation, ModifierGroupsAnnotation} {
+		if value, ok := owner.GetAnnotations()[key]; ok {
+			annotations[key] = value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	obj.SetAnnotations(annotations)
+}
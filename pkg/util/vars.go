@@ -23,6 +23,99 @@ const (
 	// KeepResourcesAnnotation indicate to keep k8s resources when uninstall a chart
 	KeepResourcesAnnotation = "captain-keep-resources"
 
+	// AgentManagedAnnotation indicate this helmrequest is deployed by the captain agents running in the target
+	// clusters, the captain in global cluster will ignore it
+	AgentManagedAnnotation = "captain-agent-managed"
+
+	// CreatorAnnotation records the user who created the helmrequest, it's set by the mutating webhook.
+	// If it's set, captain checks the user's permissions on every resource before install/upgrade/uninstall
+	CreatorAnnotation = "captain-creator"
+
+	// CreatorGroupsAnnotation records the comma separated groups of the user who created the helmrequest
+	CreatorGroupsAnnotation = "captain-creator-groups"
+
+	// ModifierAnnotation records the user who last created or updated the helmrequest, it's set by the mutating
+	// webhook. Captain checks the permissions of both the creator and the modifier, so a user can not deploy
+	// with the creator's permissions by updating it
+	ModifierAnnotation = "captain-modifier"
+
+	// ModifierGroupsAnnotation records the comma separated groups of the user who last updated the helmrequest
+	ModifierGroupsAnnotation = "captain-modifier-groups"
+
+	// ServiceAccountAnnotation specify a ServiceAccount in the release namespace, captain will use it's token
+	// instead of the cluster admin token to deploy the chart
+	ServiceAccountAnnotation = "captain-service-account"
+
+	// ImportHelmReleaseAnnotation indicate to import the release history created by helm cli (Secret/ConfigMap
+	// drivers) before sync, so the release is taken over instead of reinstalled
+	ImportHelmReleaseAnnotation = "captain-import-helm-release"
+
+	// ExportHelmReleaseAnnotation indicate to export the release history to helm cli's Secret records. If it's
+	// value is `no-sync`, captain will stop managing the helmrequest after export. It's removed once exported.
+	ExportHelmReleaseAnnotation = "captain-export-helm-release"
+
+	// RecoverPolicyAnnotation decides how to recover a release whose last upgrade/rollback was interrupted, supported
+	// values are `forward`(default), `rollback` and `fail`
+	RecoverPolicyAnnotation = "captain-recover-policy"
+
+	// TargetChangePolicyAnnotation decides what to do with the old release when the cluster, namespace or release
+	// name of a helmrequest is changed, supported values are: uninstall(default), keep, migrate
+	TargetChangePolicyAnnotation = "captain-target-change-policy"
+
+	// DeletionPolicyAnnotation decides how to delete the release when the helmrequest is deleted, supported values
+	// are: Orphan, Delete(default), DeleteAndWait
+	DeletionPolicyAnnotation = "captain-deletion-policy"
+
+	// DeletionTimeoutAnnotation is how long to wait for the resources to be deleted with the DeleteAndWait policy,
+	// it's a duration like 10m
+	DeletionTimeoutAnnotation = "captain-deletion-timeout"
+
+	// RetainKindsAnnotation is a comma separated list of kinds to keep when uninstall the release, each one
+	// is Kind, <version>/Kind or <group>/<version>/Kind
+	RetainKindsAnnotation = "captain-retain-kinds"
+
+	// RetainSelectorAnnotation is a label selector, resources matching it are kept when uninstall the release
+	RetainSelectorAnnotation = "captain-retain-selector"
+
+	// RetainedFromAnnotation is added to the resources retained on uninstall, the value is <namespace>/<release>.
+	// They can be adopted by a later HelmRequest with captain-force-adopt-resources.
+	RetainedFromAnnotation = "captain.cpaas.io/retained-from"
+
+	// DependenciesAnnotation is a comma separated list of dependencies, each one is
+	// [<namespace>/]<name>[:Synced|Ready|Healthy]. They are merged with .spec.dependencies
+	DependenciesAnnotation = "captain-dependencies"
+
+	// CascadeDeleteAnnotation indicate to delete the helmrequests depend on this one when it's deleted, otherwise
+	// the deletion waits until they are gone
+	CascadeDeleteAnnotation = "captain-cascade-delete"
+
+	// OutputsAnnotation is a yaml/json list of outputs of the release, each one extracts a field from a resource
+	// of the release or the computed values. They are written to the <name>-outputs ConfigMap/Secret
+	OutputsAnnotation = "captain-outputs"
+
+	// SyncWindowsAnnotation is a yaml/json list of allow/deny windows of the syncs, each one has a cron schedule,
+	// a duration and an optional time zone. It's also supported on the Cluster resources and kubeconfig Secrets
+	SyncWindowsAnnotation = "captain-sync-windows"
+
+	// TTLAnnotation is how long the helmrequest lives since it's created, like 72h. It's deleted when expired
+	TTLAnnotation = "captain-ttl"
+
+	// ExpiresAtAnnotation is when the helmrequest expires, in RFC3339. If it's set with TTLAnnotation, the later
+	// one wins, so both of them can be used to extend the lifetime
+	ExpiresAtAnnotation = "captain-expires-at"
+
+	// ResyncIntervalAnnotation is the interval to upgrade the release again even if the helmrequest is not changed,
+	// like 24h. It's for the charts rendering time dependent or lookup based contents
+	ResyncIntervalAnnotation = "captain-resync-interval"
+
+	// LastAppliedAnnotation is when the release was last installed/upgraded, in RFC3339. It's set by captain for
+	// the helmrequests with ResyncIntervalAnnotation, and not included in the hash of the helmrequest
+	LastAppliedAnnotation = "captain-last-applied-at"
+
+	// RetryPolicyAnnotation is a yaml/json of {maxAttempts, minBackoff, maxBackoff}, it decides how to retry the
+	// failed syncs. The helmrequest is stalled when the attempts are exhausted or the error is permanent
+	RetryPolicyAnnotation = "captain-retry-policy"
+
 	// ForceAdoptResourcesAnnotation indicate to force adopt resources when insall or upgrade a chart
 	ForceAdoptResourcesAnnotation = "captain-force-adopt-resources"
 )
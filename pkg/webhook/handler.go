@@ -1,8 +1,11 @@
 package webhook
 
 import (
-	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"fmt"
+	"os"
+
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -28,19 +31,44 @@ func RegisterHandlers(mgr manager.Manager) error {
 		return err
 	}
 
-	handler := admission.ValidatingWebhookFor(&appv1.HelmRequest{})
+	// not the default one, the target of a HelmRequest can be changed, and dependencies are checked
+	kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	handler := &admission.Webhook{Handler: &helmRequestValidator{reader: mgr.GetAPIReader(), kubeClient: kubeClient}}
 	if err := handler.InjectLogger(log.Log.WithName("validating")); err != nil {
 		wLog.Error(err, "inject logger to validating webhook handler error: ")
 		return err
 	}
 	ws.Register("/validate", handler)
 
-	handler = admission.DefaultingWebhookFor(&appv1.HelmRequest{})
+	// not the default one, we also need to record the creator
+	handler = &admission.Webhook{Handler: &helmRequestMutator{controllerUser: controllerUser()}}
 	if err := handler.InjectLogger(log.Log.WithName("mutating")); err != nil {
 		wLog.Error(err, "inject logger to mutating webhook handler error: ")
 		return err
 	}
 	ws.Register("/mutate", handler)
+
+	// the creator of bundles and generators are inherited by the HelmRequests generated
+	handler = &admission.Webhook{Handler: &creatorMutator{controllerUser: controllerUser()}}
+	if err := handler.InjectLogger(log.Log.WithName("mutating-creator")); err != nil {
+		wLog.Error(err, "inject logger to creator mutating webhook handler error: ")
+		return err
+	}
+	ws.Register("/mutate-creator", handler)
 	return nil
 
 }
+
+// controllerUser returns the username of captain's ServiceAccount, from the SERVICE_ACCOUNT_NAME and
+// KUBERNETES_NAMESPACE env. Empty if they are not set, then captain is not trusted to create HelmRequests on
+// behalf of others.
+func controllerUser() string {
+	name, ns := os.Getenv("SERVICE_ACCOUNT_NAME"), os.Getenv("KUBERNETES_NAMESPACE")
+	if name == "" || ns == "" {
+		return ""
+	}
+	return fmt.Sprintf("system:serviceaccount:%s:%s", ns, name)
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// helmRequestMutator set defaults for HelmRequest, and records the users who created and last updated it. The
+// creator annotations are read only, updates to them are reverted.
+type helmRequestMutator struct {
+	// controllerUser is the user of captain itself. The HelmRequests it creates for bundles and generators
+	// carry the creator of their owners, which is kept instead of captain's.
+	controllerUser string
+}
+
+var _ admission.Handler = &helmRequestMutator{}
+
+// Handle implements admission.Handler
+func (m *helmRequestMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	hr := &appv1.HelmRequest{}
+	if err := json.Unmarshal(req.Object.Raw, hr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	hr.Default()
+
+	switch req.Operation {
+	case admissionv1.Create:
+		if !m.isDelegated(req, hr) {
+			setCreator(hr, req.UserInfo.Username, req.UserInfo.Groups)
+			setModifier(hr, req.UserInfo.Username, req.UserInfo.Groups)
+		}
+	case admissionv1.Update:
+		old := &appv1.HelmRequest{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		keepCreator(hr, old)
+		// the updates of captain itself are not made on behalf of anyone, eg: finalizers, or the ones copied
+		// from the bundles and generators, which carry the modifier of their owners
+		if !isController(m.controllerUser, req) {
+			setModifier(hr, req.UserInfo.Username, req.UserInfo.Groups)
+		}
+	}
+
+	marshaled, err := json.Marshal(hr)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// isDelegated checks if the HelmRequest is created by captain on behalf of the creator recorded in it
+func (m *helmRequestMutator) isDelegated(req admission.Request, hr *appv1.HelmRequest) bool {
+	return isController(m.controllerUser, req) && hr.Annotations[util.CreatorAnnotation] != ""
+}
+
+// isController checks if the request is sent by captain itself
+func isController(controllerUser string, req admission.Request) bool {
+	return controllerUser != "" && req.UserInfo.Username == controllerUser
+}
+
+// creatorMutator records the users who created and last updated the HelmRequestBundles and HelmRequestGenerators,
+// the HelmRequests generated from them are deployed with the permissions of the users
+type creatorMutator struct {
+	// controllerUser is the user of captain itself, it's updates are not recorded
+	controllerUser string
+}
+
+var _ admission.Handler = &creatorMutator{}
+
+// Handle implements admission.Handler
+func (m *creatorMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	switch req.Operation {
+	case admissionv1.Create:
+		setCreator(obj, req.UserInfo.Username, req.UserInfo.Groups)
+		setModifier(obj, req.UserInfo.Username, req.UserInfo.Groups)
+	case admissionv1.Update:
+		old := &unstructured.Unstructured{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		keepCreator(obj, old)
+		if !isController(m.controllerUser, req) {
+			setModifier(obj, req.UserInfo.Username, req.UserInfo.Groups)
+		}
+	default:
+		return admission.Allowed("")
+	}
+
+	marshaled, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// setCreator records the user info into annotations
+func setCreator(obj metav1.Object, user string, groups []string) {
+	setUser(obj, util.CreatorAnnotation, util.CreatorGroupsAnnotation, user, groups)
+}
+
+// setModifier records the user who made the change into annotations
+func setModifier(obj metav1.Object, user string, groups []string) {
+	setUser(obj, util.ModifierAnnotation, util.ModifierGroupsAnnotation, user, groups)
+}
+
+func setUser(obj metav1.Object, userKey, groupsKey, user string, groups []string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[userKey] = user
+	annotations[groupsKey] = strings.Join(groups, ",")
+	obj.SetAnnotations(annotations)
+}
+
+// keepCreator reverts the changes to creator annotations
+func keepCreator(obj, old metav1.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for _, key := range []string{util.CreatorAnnotation, util.CreatorGroupsAnnotation} {
+		value, ok := old.GetAnnotations()[key]
+		if !ok {
+			delete(annotations, key)
+			continue
+		}
+		annotations[key] = value
+	}
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestMutateCreator(t *testing.T) {
+	const captain = "system:serviceaccount:cpaas-system:captain"
+	m := &helmRequestMutator{controllerUser: captain}
+
+	create := func(user string, annotations map[string]string) string {
+		hr := &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations}}
+		raw, err := json.Marshal(hr)
+		assert.Nil(t, err)
+		resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+			UserInfo:  authenticationv1.UserInfo{Username: user},
+		}})
+		assert.True(t, resp.Allowed)
+		return annotationOf(resp, annotations, util.CreatorAnnotation)
+	}
+
+	// captain creates the HelmRequests for the creator of bundles and generators
+	assert.Equal(t, "alice", create(captain, map[string]string{util.CreatorAnnotation: "alice"}))
+	assert.Equal(t, captain, create(captain, nil))
+	// the others can not create for someone else
+	assert.Equal(t, "bob", create("bob", map[string]string{util.CreatorAnnotation: "kubernetes-admin"}))
+}
+
+func TestMutateModifier(t *testing.T) {
+	const captain = "system:serviceaccount:cpaas-system:captain"
+	m := &helmRequestMutator{controllerUser: captain}
+	annotations := map[string]string{
+		util.CreatorAnnotation:  "alice",
+		util.ModifierAnnotation: "alice",
+	}
+
+	update := func(user string, annotations map[string]string) admission.Response {
+		old, err := json.Marshal(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: map[string]string{
+			util.CreatorAnnotation:  "alice",
+			util.ModifierAnnotation: "alice",
+		}}})
+		assert.Nil(t, err)
+		raw, err := json.Marshal(&appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations}})
+		assert.Nil(t, err)
+		resp := m.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: runtime.RawExtension{Raw: old},
+			UserInfo:  authenticationv1.UserInfo{Username: user, Groups: []string{"tenants"}},
+		}})
+		assert.True(t, resp.Allowed)
+		return resp
+	}
+
+	// the user who updates it is recorded, the creator is kept
+	resp := update("bob", annotations)
+	assert.Equal(t, "bob", annotationOf(resp, annotations, util.ModifierAnnotation))
+	assert.Equal(t, "tenants", annotationOf(resp, annotations, util.ModifierGroupsAnnotation))
+	assert.Equal(t, "alice", annotationOf(resp, annotations, util.CreatorAnnotation))
+
+	// can not be set by the user
+	spoofed := map[string]string{util.CreatorAnnotation: "alice", util.ModifierAnnotation: "kubernetes-admin"}
+	resp = update("bob", spoofed)
+	assert.Equal(t, "bob", annotationOf(resp, spoofed, util.ModifierAnnotation))
+
+	// the updates of captain are not recorded
+	resp = update(captain, annotations)
+	assert.Equal(t, "alice", annotationOf(resp, annotations, util.ModifierAnnotation))
+}
+
+// annotationOf returns the annotation of the object after the patches of the response
+func annotationOf(resp admission.Response, annotations map[string]string, key string) string {
+	for _, patch := range resp.Patches {
+		switch patch.Path {
+		case "/metadata/annotations":
+			value, _ := patch.Value.(map[string]interface{})[key].(string)
+			return value
+		case "/metadata/annotations/" + key:
+			if patch.Operation == "remove" {
+				return ""
+			}
+			return patch.Value.(string)
+		}
+	}
+	return annotations[key]
+}
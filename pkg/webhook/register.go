@@ -35,7 +35,9 @@ func InjectCertToWebhook(data []byte, cfg *rest.Config) error {
 	// wLog.Info("debug webhook data", "mw", mw)
 	// wLog.Info("debug data", "ca", string(mw.Webhooks[0].ClientConfig.CABundle[:]), "equal", equal == 0)
 
-	mw.Webhooks[0].ClientConfig.CABundle = decoded
+	for i := range mw.Webhooks {
+		mw.Webhooks[i].ClientConfig.CABundle = decoded
+	}
 	if _, err := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Update(context.Background(), mw, metav1.UpdateOptions{}); err != nil {
 		return err
 	}
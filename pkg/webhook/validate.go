@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/alauda/captain/pkg/dependency"
+	"github.com/alauda/captain/pkg/helm"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// helmRequestValidator validates HelmRequest. It's the same as the default one, except that the cluster,
+// namespace and release name can be updated, captain moves the release to the new target. The dependencies
+// in the captain-dependencies annotation can also be updated, as long as they do not form a cycle, and the users
+// of the HelmRequest are allowed to get the dependencies in other namespaces.
+type helmRequestValidator struct {
+	// reader reads the HelmRequests to build the dependency graph
+	reader client.Reader
+	// kubeClient creates the SubjectAccessReviews for the dependencies in other namespaces
+	kubeClient kubernetes.Interface
+}
+
+var _ admission.Handler = &helmRequestValidator{}
+
+// Handle implements admission.Handler
+func (v *helmRequestValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	hr := &appv1.HelmRequest{}
+	if err := json.Unmarshal(req.Object.Raw, hr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if hr.Namespace == "" {
+		hr.Namespace = req.Namespace
+	}
+	if err := hr.ValidateCreate(); err != nil {
+		return admission.Denied(err.Error())
+	}
+	if err := v.validateDependencies(ctx, hr); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	if req.Operation == admissionv1.Update {
+		old := &appv1.HelmRequest{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := validateUpdate(hr, old); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+	return admission.Allowed("")
+}
+
+// validateUpdate checks the immutable fields
+func validateUpdate(hr, old *appv1.HelmRequest) error {
+	_, oldChart := appv1.ParseChartName(old.Spec.Chart)
+	_, newChart := appv1.ParseChartName(hr.Spec.Chart)
+	if oldChart != newChart {
+		return fmt.Errorf("chart name cannot be updated after create")
+	}
+
+	if !reflect.DeepEqual(old.Spec.Dependencies, hr.Spec.Dependencies) {
+		return fmt.Errorf("dependencies cannot be updated after create")
+	}
+
+	if old.Spec.InstallToAllClusters != hr.Spec.InstallToAllClusters {
+		return fmt.Errorf("installToAllClusters cannot be updated after create")
+	}
+	return nil
+}
+
+// validateDependencies checks the dependencies are valid and do not form a cycle with the existing HelmRequests.
+// Only the HelmRequests in this cluster are known, the cycles across clusters are reported by the controller.
+func (v *helmRequestValidator) validateDependencies(ctx context.Context, hr *appv1.HelmRequest) error {
+	deps, err := dependency.Parse(hr)
+	if err != nil || len(deps) == 0 {
+		return err
+	}
+	if err := v.checkDependencyAccess(hr, deps); err != nil {
+		return err
+	}
+
+	var list appv1.HelmRequestList
+	if err := v.reader.List(ctx, &list); err != nil {
+		return fmt.Errorf("list helmrequests to check dependencies error: %s", err.Error())
+	}
+	var hrs []*appv1.HelmRequest
+	for i := range list.Items {
+		hrs = append(hrs, &list.Items[i])
+	}
+
+	graph := dependency.NewGraph(hrs)
+	key := dependency.Key(hr.Namespace, hr.Name)
+	graph.Set(key, deps)
+	if cycle := graph.FindCycle(key); cycle != nil {
+		return fmt.Errorf("dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// checkDependencyAccess checks the users recorded by the mutating webhook are allowed to get the dependencies in
+// other namespaces of this cluster, so a tenant can not depend on, and block the deletion of, another tenant's
+// HelmRequests. The dependencies in other clusters are checked by the controller when deleting.
+func (v *helmRequestValidator) checkDependencyAccess(hr *appv1.HelmRequest, deps []dependency.Dependency) error {
+	for _, dep := range deps {
+		if dep.Cluster != "" || dep.Namespace == hr.Namespace {
+			continue
+		}
+		allowed, err := helm.IsHelmRequestAccessAllowed(v.kubeClient, hr, "get", dep.Namespace, dep.Name)
+		if err != nil {
+			return fmt.Errorf("check access to dependency %s error: %s", dep.Key(), err.Error())
+		}
+		if !allowed {
+			return fmt.Errorf("not allowed to get dependency %s", dep.Key())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/dependency"
+	"github.com/alauda/captain/pkg/util"
+	appv1 "github.com/alauda/helm-crds/pkg/apis/app/v1"
+	"github.com/gsamokovarov/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckDependencyAccess(t *testing.T) {
+	// alice is allowed to get the helmrequests in t1 and t2, bob is only allowed in t1
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = attrs.Verb == "get" && attrs.Resource == "helmrequests" &&
+			(attrs.Namespace == "t1" || (sar.Spec.User == "alice" && attrs.Namespace == "t2"))
+		return true, sar, nil
+	})
+	v := &helmRequestValidator{kubeClient: client}
+	check := func(annotations map[string]string, deps string) error {
+		annotations[util.DependenciesAnnotation] = deps
+		hr := &appv1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "t1", Name: "app", Annotations: annotations}}
+		parsed, err := dependency.Parse(hr)
+		assert.Nil(t, err)
+		return v.checkDependencyAccess(hr, parsed)
+	}
+
+	assert.Nil(t, check(map[string]string{util.CreatorAnnotation: "alice"}, "db,t2/db"))
+	assert.NotNil(t, check(map[string]string{util.CreatorAnnotation: "bob"}, "db,t2/db"))
+	// bob updated the HelmRequest of alice, both of them need the access
+	assert.NotNil(t, check(map[string]string{util.CreatorAnnotation: "alice", util.ModifierAnnotation: "bob"}, "t2/db"))
+	// the dependencies in other clusters are checked by the controller
+	assert.Nil(t, check(map[string]string{util.CreatorAnnotation: "bob"}, "business/t2/db"))
+}